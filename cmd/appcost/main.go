@@ -0,0 +1,163 @@
+// Estimates instance hours and cost for variants of a service's scaling
+// settings under the same traffic, side by side.
+//
+//	go run ./cmd/appcost -trace trace.csv -variant F2 -variant "F1 max_instances=5"
+//
+// A variant is an instance class, automatic_scaling overrides like
+// max_idle_instances=2, or both. The app.yaml as it is always comes first, and
+// without any -variant every class in the price table is compared. A trace is
+// taken to be measured on the app.yaml's class, and moving to a faster class
+// only shortens the CPU part of each request (-cpu-share).
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"holosam/appengine/demo/pkg/appyaml"
+	"holosam/appengine/demo/pkg/autoscale"
+)
+
+// Repeatable flag.
+type variants []string
+
+func (v *variants) String() string { return strings.Join(*v, ", ") }
+
+func (v *variants) Set(s string) error {
+	*v = append(*v, s)
+	return nil
+}
+
+type variant struct {
+	name     string
+	class    string
+	settings autoscale.Settings
+}
+
+func main() {
+	var specs variants
+	appYAML := flag.String("app-yaml", "service-feed/app.yaml", "The app.yaml with the instance class and automatic_scaling settings")
+	pricesPath := flag.String("prices", "cmd/appcost/prices.yaml", "Price table by instance class")
+	flag.Var(&specs, "variant", `A class and/or overrides, like "F2 max_instances=5". Repeatable.`)
+	tracePath := flag.String("trace", "", "CSV trace from cmd/simulate -trace. Without it, -shape makes one up.")
+	shape := flag.String("shape", "cyclical", "Made up traffic: flat, cyclical or bursty")
+	peak := flag.Float64("peak", 50, "Peak requests a second for -shape")
+	latency := flag.Duration("latency", 100*time.Millisecond, "Request latency for -shape")
+	length := flag.Duration("d", 24*time.Hour, "How long -shape runs for")
+	period := flag.Duration("period", 24*time.Hour, "How long a cycle of -shape cyclical is")
+
+	model := autoscale.DefaultModel()
+	flag.DurationVar(&model.Startup, "startup", model.Startup, "How long an instance takes to start")
+	flag.DurationVar(&model.IdleTimeout, "idle-timeout", model.IdleTimeout, "How long an idle instance is kept")
+	flag.Float64Var(&model.CPUShare, "cpu-share", model.CPUShare, "Fraction of request latency spent on CPU")
+	flag.Parse()
+
+	if err := run(*appYAML, *pricesPath, specs, *tracePath, *shape, *peak, *latency, *length, *period, model); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(appYAML, pricesPath string, specs []string, tracePath, shape string, peak float64, latency, length, period time.Duration, model autoscale.Model) error {
+	if model.CPUShare <= 0 || model.CPUShare > 1 {
+		return fmt.Errorf("-cpu-share should be above 0 and up to 1")
+	}
+	prices, err := autoscale.LoadPrices(pricesPath)
+	if err != nil {
+		return err
+	}
+
+	f, err := appyaml.Load(appYAML)
+	if err != nil {
+		return err
+	}
+	base := variant{name: "app.yaml", class: "F1"}
+	if class := appyaml.Lookup(f.Root, "instance_class"); class != nil {
+		base.class = class.Value
+	}
+	if base.settings, err = autoscale.FromAppYAML(f); err != nil {
+		return err
+	}
+	baseClass, err := prices.Class(base.class)
+	if err != nil {
+		return fmt.Errorf("%s: %w", appYAML, err)
+	}
+
+	if len(specs) == 0 {
+		for name := range prices.Classes {
+			if name != base.class {
+				specs = append(specs, name)
+			}
+		}
+		sort.Strings(specs)
+	}
+	list := []variant{base}
+	for _, spec := range specs {
+		v, err := parseVariant(spec, base)
+		if err != nil {
+			return fmt.Errorf("-variant %q: %w", spec, err)
+		}
+		list = append(list, v)
+	}
+
+	var trace autoscale.Trace
+	if tracePath != "" {
+		trace, err = autoscale.ReadTraceFile(tracePath)
+	} else {
+		trace, err = autoscale.Shape(shape, length, peak, latency, period)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%v of traffic, %.0f requests, %s prices in %s\n\n", time.Duration(len(trace))*time.Second, sum(trace), prices.Currency, prices.Region)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "variant\tclass\tinstance hours\tpeak\tstarts\tmean queue\tcost\tmonthly hours\tmonthly cost\t")
+	for _, v := range list {
+		class, err := prices.Class(v.class)
+		if err != nil {
+			return fmt.Errorf("-variant %q: %w", v.name, err)
+		}
+		m, t := autoscale.OnClass(model, trace, baseClass, class)
+		result := autoscale.Simulate(v.settings, m, t)
+		e := class.Estimate(result)
+		fmt.Fprintf(w, "%s\t%s\t%.1f\t%d\t%d\t%v\t%.2f\t%.0f\t%.2f\t\n", v.name, v.class, e.InstanceHours,
+			result.PeakInstances, result.Started, result.MeanQueueDelay.Round(time.Millisecond), e.Cost, e.MonthlyHours, e.MonthlyCost)
+	}
+	w.Flush()
+	fmt.Println("\nMonthly is the traffic repeated for 30 days, after the free tier.")
+	return nil
+}
+
+// Like "F2 max_instances=5": a class, automatic_scaling overrides, or both.
+func parseVariant(spec string, base variant) (variant, error) {
+	v := base
+	v.name = spec
+	for _, field := range strings.Fields(spec) {
+		key, value, ok := strings.Cut(field, "=")
+		switch {
+		case !ok:
+			v.class = field
+		case key == "instance_class":
+			v.class = value
+		default:
+			if err := v.settings.Set(key, value); err != nil {
+				return variant{}, err
+			}
+		}
+	}
+	return v, nil
+}
+
+func sum(trace autoscale.Trace) float64 {
+	total := 0.0
+	for _, p := range trace {
+		total += p.Requests
+	}
+	return total
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"holosam/appengine/demo/pkg/appyaml"
+)
+
+// The parts of an App Engine Version resource that app.yaml sets.
+type version struct {
+	// Like apps/PROJECT/services/SERVICE/versions/ID.
+	Name             string                 `json:"name"`
+	InstanceClass    string                 `json:"instanceClass"`
+	AutomaticScaling map[string]interface{} `json:"automaticScaling"`
+	BasicScaling     map[string]interface{} `json:"basicScaling"`
+	ManualScaling    map[string]interface{} `json:"manualScaling"`
+	EnvVariables     map[string]string      `json:"envVariables"`
+	// `gcloud app versions list` wraps the resource.
+	Version *version `json:"version"`
+}
+
+// Settings by cloud ID, like app:PROJECT/service:SERVICE.
+func loadDeployed(path string) (map[string]appyaml.Settings, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read deployed snapshot error: %w", err)
+	}
+
+	var versions []version
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '[' {
+		err = json.Unmarshal(data, &versions)
+	} else {
+		versions = make([]version, 1)
+		err = json.Unmarshal(data, &versions[0])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse deployed snapshot error: %w", err)
+	}
+
+	deployed := make(map[string]appyaml.Settings, len(versions))
+	for _, v := range versions {
+		if v.Version != nil {
+			v = *v.Version
+		}
+		parts := strings.Split(v.Name, "/")
+		if len(parts) != 6 || parts[0] != "apps" || parts[2] != "services" {
+			return nil, fmt.Errorf("%s: version name %q should be like apps/PROJECT/services/SERVICE/versions/ID", path, v.Name)
+		}
+		id := fmt.Sprintf("app:%s/service:%s", parts[1], parts[3])
+		if _, ok := deployed[id]; ok {
+			return nil, fmt.Errorf("%s: more than one version of %s", path, id)
+		}
+		deployed[id] = v.settings()
+	}
+	return deployed, nil
+}
+
+// In the same keys and forms as appyaml.File.Settings.
+func (v *version) settings() appyaml.Settings {
+	settings := make(appyaml.Settings)
+	if v.InstanceClass != "" {
+		settings["instance_class"] = &appyaml.Setting{Value: v.InstanceClass}
+	}
+
+	scaling := map[string]map[string]interface{}{
+		"automatic_scaling": v.AutomaticScaling,
+		"basic_scaling":     v.BasicScaling,
+		"manual_scaling":    v.ManualScaling,
+	}
+	for block, values := range scaling {
+		for key, value := range values {
+			// The standard environment's settings are grouped in the API but
+			// not in app.yaml.
+			if nested, ok := value.(map[string]interface{}); ok && key == "standardSchedulerSettings" {
+				for key, value := range nested {
+					addScaling(settings, block, key, value)
+				}
+				continue
+			}
+			addScaling(settings, block, key, value)
+		}
+	}
+
+	for key, value := range v.EnvVariables {
+		settings["env_variables."+key] = &appyaml.Setting{Value: value}
+	}
+	return settings
+}
+
+func addScaling(settings appyaml.Settings, block, key string, value interface{}) {
+	var s string
+	switch value := value.(type) {
+	case float64:
+		s = strconv.FormatFloat(value, 'f', -1, 64)
+	case string:
+		s = appyaml.NormalizeValue(value)
+	case bool:
+		s = strconv.FormatBool(value)
+	default:
+		// Legacy nested settings like cpuUtilization have no app.yaml key.
+		return
+	}
+	settings[block+"."+snakeCase(key)] = &appyaml.Setting{Value: s}
+}
+
+// maxPendingLatency to max_pending_latency.
+func snakeCase(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsUpper(r) {
+			b.WriteByte('_')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"holosam/appengine/demo/pkg/appyaml"
+)
+
+func TestLoadDeployed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "versions.json")
+	err := os.WriteFile(path, []byte(`{
+  "name": "apps/demo/services/feed/versions/v1",
+  "instanceClass": "F1",
+  "automaticScaling": {
+    "maxPendingLatency": "0.300s",
+    "standardSchedulerSettings": {"maxInstances": 10, "targetCpuUtilization": 0.55}
+  },
+  "envVariables": {"MAX_THREADS": "10"}
+}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := loadDeployed(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]appyaml.Settings{
+		"app:demo/service:feed": {
+			"instance_class":                           {Value: "F1"},
+			"automatic_scaling.max_pending_latency":    {Value: "300ms"},
+			"automatic_scaling.max_instances":          {Value: "10"},
+			"automatic_scaling.target_cpu_utilization": {Value: "0.55"},
+			"env_variables.MAX_THREADS":                {Value: "10"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+}
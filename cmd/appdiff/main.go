@@ -0,0 +1,227 @@
+// Diffs the scaling and env settings of the app.yaml files listed in
+// .github/flightcrew.yaml.
+//
+//	go run ./cmd/appdiff                           # each service side by side
+//	go run ./cmd/appdiff -from HEAD~5              # each service at HEAD~5 and now
+//	go run ./cmd/appdiff -deployed versions.json   # each service and what's deployed
+//
+// -to picks the revision to compare instead of the working tree. The deployed
+// snapshot is the JSON from
+// `gcloud app versions describe VERSION --service SERVICE --format json`, or a
+// list of them. Settings commented out in app.yaml show as "# value", and
+// count as not set.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"holosam/appengine/demo/pkg/appyaml"
+
+	"gopkg.in/yaml.v3"
+)
+
+type manifest struct {
+	Version int `yaml:"version"`
+	Configs []struct {
+		CloudID string `yaml:"cloud_id"`
+		File    string `yaml:"file"`
+	} `yaml:"configs"`
+}
+
+// One table: rows of settings across named columns.
+type table struct {
+	Name    string        `json:"name,omitempty"`
+	Columns []string      `json:"columns"`
+	Rows    []appyaml.Row `json:"rows"`
+	columns []appyaml.Settings
+}
+
+func main() {
+	manifestPath := flag.String("manifest", ".github/flightcrew.yaml", "Maps cloud IDs to app.yaml files, relative to the repo root")
+	from := flag.String("from", "", "Git revision to compare each service against")
+	to := flag.String("to", "", "Git revision to compare, instead of the working tree")
+	deployed := flag.String("deployed", "", "JSON snapshot of the deployed versions to compare each service against")
+	all := flag.Bool("all", false, "Show settings that are the same too")
+	asJSON := flag.Bool("json", false, "Print the tables as JSON")
+	flag.Parse()
+
+	if err := run(*manifestPath, *from, *to, *deployed, *all, *asJSON); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(manifestPath, from, to, deployedPath string, all, asJSON bool) error {
+	root, err := git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+	root = strings.TrimSpace(root)
+
+	data, err := os.ReadFile(filepath.Join(root, manifestPath))
+	if err != nil {
+		return fmt.Errorf("read manifest error: %w", err)
+	}
+	var m manifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("%s: %w", manifestPath, err)
+	}
+	if len(m.Configs) == 0 {
+		return fmt.Errorf("%s lists no configs", manifestPath)
+	}
+
+	var versions map[string]appyaml.Settings
+	if deployedPath != "" {
+		if versions, err = loadDeployed(deployedPath); err != nil {
+			return err
+		}
+	}
+
+	toName := "working tree"
+	if to != "" {
+		toName = to
+	}
+
+	tables := make([]*table, 0)
+	// Without -from or -deployed, every service goes in one table.
+	sideBySide := &table{}
+	for _, c := range m.Configs {
+		current, err := load(root, to, c.File)
+		if err != nil {
+			return err
+		}
+		if from == "" && deployedPath == "" {
+			sideBySide.add(shortName(c.CloudID), current)
+			continue
+		}
+
+		t := &table{Name: c.CloudID}
+		if from != "" {
+			before, err := load(root, from, c.File)
+			if err != nil {
+				return err
+			}
+			t.add(from, before)
+		}
+		t.add(toName, current)
+		if deployedPath != "" {
+			if settings, ok := versions[c.CloudID]; ok {
+				t.add("deployed", settings)
+			} else {
+				fmt.Fprintf(os.Stderr, "No deployed version of %s in %s\n", c.CloudID, deployedPath)
+			}
+		}
+		if len(t.columns) > 1 {
+			tables = append(tables, t)
+		}
+	}
+	if len(sideBySide.columns) > 0 {
+		tables = append(tables, sideBySide)
+	}
+
+	for _, t := range tables {
+		t.Rows = appyaml.Compare(t.columns...)
+		if !all {
+			rows := t.Rows[:0]
+			for _, row := range t.Rows {
+				if row.Differs {
+					rows = append(rows, row)
+				}
+			}
+			t.Rows = rows
+		}
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(tables)
+	}
+	for i, t := range tables {
+		if i > 0 {
+			fmt.Println()
+		}
+		t.print()
+	}
+	return nil
+}
+
+func (t *table) add(name string, settings appyaml.Settings) {
+	t.Columns = append(t.Columns, name)
+	t.columns = append(t.columns, settings)
+}
+
+func (t *table) print() {
+	if t.Name != "" {
+		fmt.Println(t.Name)
+	}
+	if len(t.Rows) == 0 {
+		fmt.Println("No differences")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "\t%s\n", strings.Join(t.Columns, "\t"))
+	for _, row := range t.Rows {
+		mark := " "
+		if row.Differs {
+			mark = "!"
+		}
+		values := make([]string, len(row.Values))
+		for i, v := range row.Values {
+			values[i] = v.String()
+		}
+		fmt.Fprintf(w, "%s %s\t%s\n", mark, row.Key, strings.Join(values, "\t"))
+	}
+	w.Flush()
+}
+
+// The settings of an app.yaml in the working tree, or at a revision.
+func load(root, rev, path string) (appyaml.Settings, error) {
+	var data []byte
+	if rev == "" {
+		var err error
+		if data, err = os.ReadFile(filepath.Join(root, path)); err != nil {
+			return nil, fmt.Errorf("read app.yaml error: %w", err)
+		}
+	} else {
+		out, err := git("-C", root, "show", rev+":"+path)
+		if err != nil {
+			return nil, err
+		}
+		data = []byte(out)
+		path = rev + ":" + path
+	}
+
+	f, err := appyaml.Parse(path, data)
+	if err != nil {
+		return nil, err
+	}
+	return f.Settings(), nil
+}
+
+// app:PROJECT/service:feed to feed.
+func shortName(cloudID string) string {
+	if i := strings.LastIndex(cloudID, "service:"); i >= 0 {
+		return cloudID[i+len("service:"):]
+	}
+	return cloudID
+}
+
+func git(args ...string) (string, error) {
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		if exit, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("git %s: %s", strings.Join(args, " "), strings.TrimSpace(string(exit.Stderr)))
+		}
+		return "", fmt.Errorf("git error: %w", err)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"holosam/appengine/demo/pkg/appyaml"
+	"holosam/appengine/demo/pkg/feedsvc"
+	"holosam/appengine/demo/pkg/usersvc"
+	"holosam/appengine/demo/pkg/util"
+
+	"gopkg.in/yaml.v3"
+)
+
+// From https://cloud.google.com/appengine/docs/standard/reference/app-yaml
+var (
+	topLevelKeys = set("runtime", "service", "instance_class", "inbound_services",
+		"automatic_scaling", "basic_scaling", "manual_scaling", "env_variables",
+		"build_env_variables", "handlers", "entrypoint", "main", "app_engine_apis",
+		"default_expiration", "error_handlers", "includes", "service_account",
+		"vpc_access_connector")
+
+	runtimes = set("go111", "go112", "go113", "go114", "go115", "go116", "go118",
+		"go119", "go120", "go121", "go122", "go123")
+
+	automaticClasses = set("F1", "F2", "F4", "F4_1G")
+	basicClasses     = set("B1", "B2", "B4", "B4_1G", "B8")
+
+	inboundServices = set("mail", "mail_bounce", "xmpp_message", "xmpp_presence",
+		"xmpp_subscribe", "xmpp_error", "warmup")
+
+	handlerKeys = set("url", "script", "static_files", "static_dir", "upload",
+		"mime_type", "expiration", "http_headers", "secure", "login",
+		"redirect_http_response_code", "auth_fail_action", "application_readable",
+		"require_matching_file")
+	handlerEnums = map[string]map[string]bool{
+		"secure":                      set("optional", "always", "never"),
+		"login":                       set("optional", "required", "admin"),
+		"auth_fail_action":            set("redirect", "unauthorized"),
+		"redirect_http_response_code": set("301", "302", "303", "307"),
+	}
+
+	serviceName = regexp.MustCompile(`^[a-z\d][a-z\d-]{0,62}$`)
+	// App Engine only takes seconds and milliseconds, like 300ms or 3.8s.
+	latencyFormat = regexp.MustCompile(`^(\d+(?:\.\d+)?)(ms|s)$`)
+	timeoutFormat = regexp.MustCompile(`^\d+[smh]$`)
+
+	// What each service reads from its environment. Other services are
+	// checked against all of them.
+	serviceConfigs = map[string]interface{}{
+		util.ServiceFeed: &feedsvc.ServiceConfig{},
+		util.ServiceUser: &usersvc.ServiceConfig{},
+	}
+)
+
+type numRange struct {
+	min, max float64
+	integer  bool
+	// Also allows the value "automatic".
+	automatic bool
+}
+
+var automaticScaling = map[string]numRange{
+	"target_cpu_utilization":        {min: 0.5, max: 0.95},
+	"target_throughput_utilization": {min: 0.5, max: 0.95},
+	"max_concurrent_requests":       {min: 1, max: 1000, integer: true},
+	"max_instances":                 {min: 0, max: 2147483647, integer: true},
+	"min_instances":                 {min: 0, max: 1000, integer: true},
+	"max_idle_instances":            {min: 0, max: 1000, integer: true, automatic: true},
+	"min_idle_instances":            {min: 0, max: 1000, integer: true},
+}
+
+// In seconds.
+var automaticLatencies = map[string]numRange{
+	"max_pending_latency": {min: 0, max: 15, automatic: true},
+	"min_pending_latency": {min: 0, max: 15, automatic: true},
+}
+
+type problem struct {
+	line int
+	msg  string
+}
+
+type linter struct {
+	file     *appyaml.File
+	problems []problem
+}
+
+func (l *linter) errorf(node *yaml.Node, format string, args ...interface{}) {
+	l.problems = append(l.problems, problem{line: node.Line, msg: fmt.Sprintf(format, args...)})
+}
+
+// Every problem in the file, sorted by line.
+func lint(f *appyaml.File) []problem {
+	l := &linter{file: f}
+	root := f.Root
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if key := root.Content[i]; !topLevelKeys[key.Value] {
+			l.errorf(key, "unknown key %s", key.Value)
+		}
+	}
+
+	if runtime := appyaml.Lookup(root, "runtime"); runtime == nil {
+		l.problems = append(l.problems, problem{line: 1, msg: "runtime is required"})
+	} else if !runtimes[runtime.Value] {
+		l.errorf(runtime, "runtime %q isn't a supported Go runtime", runtime.Value)
+	}
+
+	// App Engine's name for a file without one.
+	service := "default"
+	if node := appyaml.Lookup(root, "service"); node != nil {
+		service = node.Value
+		if !serviceName.MatchString(service) {
+			l.errorf(node, "service %q should be lowercase letters, digits and dashes", service)
+		}
+	}
+
+	l.lintScaling(root)
+	l.lintInboundServices(appyaml.Lookup(root, "inbound_services"))
+	l.lintHandlers(appyaml.Lookup(root, "handlers"))
+	l.lintEnv(service)
+
+	sort.SliceStable(l.problems, func(i, j int) bool { return l.problems[i].line < l.problems[j].line })
+	return l.problems
+}
+
+func (l *linter) lintScaling(root *yaml.Node) {
+	var scalingKey string
+	for _, key := range []string{"automatic_scaling", "basic_scaling", "manual_scaling"} {
+		node := appyaml.Lookup(root, key)
+		if node == nil {
+			continue
+		}
+		if scalingKey != "" {
+			l.errorf(node, "%s and %s can't both be set", scalingKey, key)
+			continue
+		}
+		scalingKey = key
+		if node.Kind != yaml.MappingNode {
+			l.errorf(node, "%s should be a mapping", key)
+			continue
+		}
+
+		switch key {
+		case "automatic_scaling":
+			l.lintAutomatic(node)
+		case "basic_scaling":
+			l.lintBasic(node)
+		case "manual_scaling":
+			instances := appyaml.Lookup(node, "instances")
+			if instances == nil {
+				l.errorf(node, "manual_scaling.instances is required")
+			} else {
+				l.checkRange(instances, "manual_scaling.instances", numRange{min: 1, max: 1000, integer: true})
+			}
+		}
+	}
+
+	if class := appyaml.Lookup(root, "instance_class"); class != nil {
+		switch {
+		case scalingKey == "" || scalingKey == "automatic_scaling":
+			if !automaticClasses[class.Value] {
+				l.errorf(class, "instance_class %s doesn't work with automatic scaling, use one of %s", class.Value, keys(automaticClasses))
+			}
+		default:
+			if !basicClasses[class.Value] {
+				l.errorf(class, "instance_class %s doesn't work with %s, use one of %s", class.Value, scalingKey, keys(basicClasses))
+			}
+		}
+	}
+}
+
+func (l *linter) lintAutomatic(node *yaml.Node) {
+	values := make(map[string]float64)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		name := "automatic_scaling." + key.Value
+
+		if r, ok := automaticScaling[key.Value]; ok {
+			if v, ok := l.checkRange(value, name, r); ok {
+				values[key.Value] = v
+			}
+		} else if r, ok := automaticLatencies[key.Value]; ok {
+			if v, ok := l.checkLatency(value, name, r); ok {
+				values[key.Value] = v
+			}
+		} else {
+			l.errorf(key, "unknown key %s", name)
+		}
+	}
+
+	for _, pair := range [][2]string{
+		{"min_instances", "max_instances"},
+		{"min_idle_instances", "max_idle_instances"},
+		{"min_pending_latency", "max_pending_latency"},
+	} {
+		min, minOK := values[pair[0]]
+		max, maxOK := values[pair[1]]
+		// A max_instances of 0 means no limit.
+		if minOK && maxOK && min > max && !(pair[1] == "max_instances" && max == 0) {
+			l.errorf(appyaml.Lookup(node, pair[0]), "automatic_scaling.%s is more than %s", pair[0], pair[1])
+		}
+	}
+}
+
+func (l *linter) lintBasic(node *yaml.Node) {
+	if max := appyaml.Lookup(node, "max_instances"); max == nil {
+		l.errorf(node, "basic_scaling.max_instances is required")
+	} else {
+		l.checkRange(max, "basic_scaling.max_instances", numRange{min: 1, max: 200, integer: true})
+	}
+	if timeout := appyaml.Lookup(node, "idle_timeout"); timeout != nil && !timeoutFormat.MatchString(timeout.Value) {
+		l.errorf(timeout, "basic_scaling.idle_timeout %q should be a whole number of s, m or h", timeout.Value)
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		if key := node.Content[i]; key.Value != "max_instances" && key.Value != "idle_timeout" {
+			l.errorf(key, "unknown key basic_scaling.%s", key.Value)
+		}
+	}
+}
+
+func (l *linter) checkRange(node *yaml.Node, name string, r numRange) (float64, bool) {
+	if r.automatic && node.Value == "automatic" {
+		return 0, false
+	}
+	if node.Kind != yaml.ScalarNode {
+		l.errorf(node, "%s should be a number", name)
+		return 0, false
+	}
+
+	v, err := strconv.ParseFloat(node.Value, 64)
+	if err != nil || (r.integer && v != float64(int64(v))) {
+		kind := "a number"
+		if r.integer {
+			kind = "a whole number"
+		}
+		if r.automatic {
+			kind += " or automatic"
+		}
+		l.errorf(node, "%s %q should be %s", name, node.Value, kind)
+		return 0, false
+	}
+	if v < r.min || v > r.max {
+		l.errorf(node, "%s %v is outside %v to %v", name, node.Value, r.min, r.max)
+		return v, false
+	}
+	return v, true
+}
+
+// Returns the latency in seconds.
+func (l *linter) checkLatency(node *yaml.Node, name string, r numRange) (float64, bool) {
+	if node.Value == "automatic" {
+		return 0, false
+	}
+
+	m := latencyFormat.FindStringSubmatch(node.Value)
+	if m == nil {
+		l.errorf(node, "%s %q should be a number with ms or s, like 300ms or 3.8s", name, node.Value)
+		return 0, false
+	}
+	v, _ := strconv.ParseFloat(m[1], 64)
+	if m[2] == "ms" {
+		v /= 1000
+	}
+	if v < r.min || v > r.max {
+		l.errorf(node, "%s %s is outside %vs to %vs", name, node.Value, r.min, r.max)
+		return v, false
+	}
+	return v, true
+}
+
+func (l *linter) lintInboundServices(node *yaml.Node) {
+	if node == nil {
+		return
+	}
+	if node.Kind != yaml.SequenceNode {
+		l.errorf(node, "inbound_services should be a list")
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, item := range node.Content {
+		switch {
+		case !inboundServices[item.Value]:
+			l.errorf(item, "unknown inbound service %q, use one of %s", item.Value, keys(inboundServices))
+		case seen[item.Value]:
+			l.errorf(item, "inbound service %s is listed twice", item.Value)
+		}
+		seen[item.Value] = true
+	}
+}
+
+func (l *linter) lintHandlers(node *yaml.Node) {
+	if node == nil {
+		return
+	}
+	if node.Kind != yaml.SequenceNode {
+		l.errorf(node, "handlers should be a list")
+		return
+	}
+
+	for _, h := range node.Content {
+		if h.Kind != yaml.MappingNode {
+			l.errorf(h, "each handler should be a mapping")
+			continue
+		}
+		for i := 0; i+1 < len(h.Content); i += 2 {
+			key, value := h.Content[i], h.Content[i+1]
+			if !handlerKeys[key.Value] {
+				l.errorf(key, "unknown handler key %s", key.Value)
+			} else if allowed, ok := handlerEnums[key.Value]; ok && !allowed[value.Value] {
+				l.errorf(value, "handler %s %q should be one of %s", key.Value, value.Value, keys(allowed))
+			}
+		}
+
+		url := appyaml.Lookup(h, "url")
+		if url == nil {
+			l.errorf(h, "handler is missing url")
+		} else if !strings.HasPrefix(url.Value, "/") {
+			l.errorf(url, "handler url %q should start with /", url.Value)
+		} else if _, err := regexp.Compile(url.Value); err != nil {
+			l.errorf(url, "handler url %q isn't a valid pattern: %v", url.Value, err)
+		}
+
+		targets := 0
+		for _, key := range []string{"script", "static_files", "static_dir"} {
+			if appyaml.Lookup(h, key) != nil {
+				targets++
+			}
+		}
+		if targets != 1 {
+			l.errorf(h, "handler should have exactly one of script, static_files and static_dir")
+		}
+
+		if files := appyaml.Lookup(h, "static_files"); files != nil {
+			upload := appyaml.Lookup(h, "upload")
+			if upload == nil {
+				l.errorf(files, "static_files needs an upload pattern")
+			} else if re, err := regexp.Compile(upload.Value); err != nil {
+				l.errorf(upload, "upload %q isn't a valid pattern: %v", upload.Value, err)
+			} else if !strings.Contains(files.Value, `\`) && !re.MatchString(files.Value) {
+				l.errorf(upload, "upload %q doesn't match static_files %s", upload.Value, files.Value)
+			}
+			// Backreferences like \1 depend on the request.
+			if !strings.Contains(files.Value, `\`) {
+				l.checkPath(files, files.Value)
+			}
+		}
+		if dir := appyaml.Lookup(h, "static_dir"); dir != nil {
+			l.checkPath(dir, dir.Value)
+		}
+	}
+}
+
+// Static paths are relative to the directory app.yaml is in.
+func (l *linter) checkPath(node *yaml.Node, path string) {
+	full := filepath.Join(filepath.Dir(l.file.Path), path)
+	if _, err := os.Stat(full); err != nil {
+		l.errorf(node, "%s doesn't exist (looked for %s)", path, full)
+	}
+}
+
+func (l *linter) lintEnv(service string) {
+	node := appyaml.Lookup(l.file.Root, "env_variables")
+	entries, err := appyaml.Entries(l.file.Path, node)
+	if err != nil {
+		l.problems = append(l.problems, problem{line: node.Line, msg: err.Error()})
+		return
+	}
+
+	configs := make([]interface{}, 0)
+	if cfg, ok := serviceConfigs[service]; ok {
+		configs = append(configs, cfg)
+	} else {
+		for _, cfg := range serviceConfigs {
+			configs = append(configs, cfg)
+		}
+	}
+
+	for _, e := range entries {
+		line := &yaml.Node{Line: e.Line}
+		if e.Key == "PORT" || e.Key == util.EnvCloudProject || strings.HasPrefix(e.Key, "GAE_") {
+			l.errorf(line, "%s is set by App Engine and can't be in env_variables", e.Key)
+			continue
+		}
+
+		secret := false
+		for _, cfg := range configs {
+			secret = secret || util.SecretNames(cfg)[e.Key]
+		}
+		if secret {
+			l.errorf(line, "%s is a secret, so it shouldn't be in app.yaml, see SECRETS_BACKEND", e.Key)
+			continue
+		}
+
+		known := false
+		for _, cfg := range configs {
+			ok, err := util.CheckEnv(cfg, e.Key, e.Value)
+			if err != nil {
+				l.errorf(line, "%s: %v", e.Key, err)
+			}
+			known = known || ok || util.EnvNames(cfg)[e.Key]
+			if ok {
+				break
+			}
+		}
+		if !known {
+			l.errorf(line, "%s isn't read by service %s", e.Key, service)
+		}
+	}
+}
+
+func set(values ...string) map[string]bool {
+	m := make(map[string]bool, len(values))
+	for _, v := range values {
+		m[v] = true
+	}
+	return m
+}
+
+func keys(m map[string]bool) string {
+	list := make([]string, 0, len(m))
+	for k := range m {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"holosam/appengine/demo/pkg/appyaml"
+)
+
+func TestLint(t *testing.T) {
+	f, err := appyaml.Parse("service-feed/app.yaml", []byte(`runtime: go122
+service: feed
+instance_class: F1
+automatic_scaling:
+  target_cpu_utilization: 0.55
+  max_pending_latency: 3.8
+  min_idle_instances: 2
+  max_idle_instances: 1
+env_variables:
+  SELF_DOCS: 3
+  INCLUDE_FOLLOWER: true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []problem{
+		{6, `automatic_scaling.max_pending_latency "3.8" should be a number with ms or s, like 300ms or 3.8s`},
+		{7, "automatic_scaling.min_idle_instances is more than max_idle_instances"},
+		{11, "INCLUDE_FOLLOWER isn't read by service feed"},
+	}
+	if got := lint(f); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,49 @@
+// Checks App Engine app.yaml files for mistakes that otherwise only show up at
+// deploy time. Prints each problem as file:line: message and exits with 1 if
+// there are any.
+//
+//	go run ./cmd/applint [app.yaml ...]
+//
+// With no arguments, it checks every */app.yaml.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"holosam/appengine/demo/pkg/appyaml"
+)
+
+func main() {
+	flag.Parse()
+
+	paths := flag.Args()
+	if len(paths) == 0 {
+		paths, _ = filepath.Glob("*/app.yaml")
+	}
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "No app.yaml files to check")
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, path := range paths {
+		f, err := appyaml.Load(path)
+		if err != nil {
+			fmt.Println(err)
+			failed = true
+			continue
+		}
+
+		for _, p := range lint(f) {
+			fmt.Printf("%s:%d: %s\n", path, p.line, p.msg)
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
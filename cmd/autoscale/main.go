@@ -0,0 +1,166 @@
+// Predicts how App Engine would scale a service's automatic_scaling settings
+// under a traffic trace, from cmd/simulate -trace or a made up shape.
+//
+//	go run ./cmd/autoscale -app-yaml service-feed/app.yaml -trace trace.csv
+//	go run ./cmd/autoscale -shape bursty -peak 80 -set max_idle_instances=2
+//
+// See pkg/autoscale for the model and what it leaves out.
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"holosam/appengine/demo/pkg/appyaml"
+	"holosam/appengine/demo/pkg/autoscale"
+)
+
+// Repeatable key=value flag.
+type overrides []string
+
+func (o *overrides) String() string { return strings.Join(*o, ",") }
+
+func (o *overrides) Set(v string) error {
+	if !strings.Contains(v, "=") {
+		return fmt.Errorf("should be key=value, like max_instances=5")
+	}
+	*o = append(*o, v)
+	return nil
+}
+
+func main() {
+	var set overrides
+	appYAML := flag.String("app-yaml", "service-feed/app.yaml", "The app.yaml with the automatic_scaling settings")
+	flag.Var(&set, "set", "Overrides an automatic_scaling setting, like max_instances=5. Repeatable.")
+	tracePath := flag.String("trace", "", "CSV trace from cmd/simulate -trace. Without it, -shape makes one up.")
+	shape := flag.String("shape", "flat", "Made up traffic: flat, cyclical or bursty")
+	peak := flag.Float64("peak", 50, "Peak requests a second for -shape")
+	latency := flag.Duration("latency", 100*time.Millisecond, "Request latency for -shape")
+	length := flag.Duration("d", 30*time.Minute, "How long -shape runs for")
+	period := flag.Duration("period", 20*time.Minute, "How long a cycle of -shape cyclical is")
+
+	model := autoscale.DefaultModel()
+	flag.DurationVar(&model.Startup, "startup", model.Startup, "How long an instance takes to start")
+	flag.DurationVar(&model.IdleTimeout, "idle-timeout", model.IdleTimeout, "How long an idle instance is kept")
+	flag.Float64Var(&model.CPUShare, "cpu-share", model.CPUShare, "Fraction of request latency spent on CPU")
+
+	interval := flag.Duration("interval", time.Minute, "How much of the trace each row of the report covers")
+	asCSV := flag.Bool("csv", false, "Print every second as CSV instead of the report")
+	flag.Parse()
+
+	settings, trace, err := load(*appYAML, set, *tracePath, *shape, *peak, *latency, *length, *period)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if model.CPUShare <= 0 || model.CPUShare > 1 {
+		fmt.Fprintln(os.Stderr, "-cpu-share should be above 0 and up to 1")
+		os.Exit(2)
+	}
+
+	result := autoscale.Simulate(settings, model, trace)
+	if *asCSV {
+		printCSV(result)
+		return
+	}
+	printReport(result, *interval)
+}
+
+func load(appYAML string, set overrides, tracePath, shape string, peak float64, latency, length, period time.Duration) (autoscale.Settings, autoscale.Trace, error) {
+	f, err := appyaml.Load(appYAML)
+	if err != nil {
+		return autoscale.Settings{}, nil, err
+	}
+	settings, err := autoscale.FromAppYAML(f)
+	if err != nil {
+		return autoscale.Settings{}, nil, err
+	}
+	for _, kv := range set {
+		parts := strings.SplitN(kv, "=", 2)
+		if err := settings.Set(parts[0], parts[1]); err != nil {
+			return autoscale.Settings{}, nil, fmt.Errorf("-set %s", err)
+		}
+	}
+
+	if tracePath == "" {
+		trace, err := autoscale.Shape(shape, length, peak, latency, period)
+		return settings, trace, err
+	}
+	trace, err := autoscale.ReadTraceFile(tracePath)
+	return settings, trace, err
+}
+
+func printReport(r autoscale.Result, interval time.Duration) {
+	fmt.Printf("requests:             %.0f\n", r.Requests)
+	fmt.Printf("instance hours:       %.2f\n", r.InstanceHours)
+	fmt.Printf("peak instances:       %d\n", r.PeakInstances)
+	fmt.Printf("instances started:    %d (%d with requests waiting)\n", r.Started, r.ColdStarts)
+	fmt.Printf("mean queue delay:     %v\n", r.MeanQueueDelay.Round(time.Millisecond))
+	fmt.Printf("max pending latency:  %v\n", r.MaxPendingLatency.Round(time.Millisecond))
+	fmt.Printf("seconds over max_pending_latency: %d\n\n", r.SlowSeconds)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "time\treq/s\tinstances\tidle\tstarted\tcold\tmax queued\tmax pending\t")
+	size := int(math.Max(interval.Seconds(), 1))
+	for i := 0; i < len(r.Steps); i += size {
+		steps := r.Steps[i:min(i+size, len(r.Steps))]
+
+		var requests, queued float64
+		var instances, idle, started, cold int
+		var pending time.Duration
+		for _, step := range steps {
+			requests += step.Requests
+			instances = max(instances, step.Instances+step.Starting)
+			idle = max(idle, step.Idle)
+			started += step.Started
+			cold += step.ColdStarts
+			queued = math.Max(queued, step.Queued)
+			if step.PendingLatency > pending {
+				pending = step.PendingLatency
+			}
+		}
+		fmt.Fprintf(w, "%v\t%.1f\t%d\t%d\t%d\t%d\t%.0f\t%v\t\n", steps[0].At, requests/float64(len(steps)),
+			instances, idle, started, cold, queued, pending.Round(time.Millisecond))
+	}
+	w.Flush()
+}
+
+func printCSV(r autoscale.Result) {
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"second", "requests", "instances", "starting", "idle", "started", "cold_starts", "queued", "pending_ms"})
+	for _, step := range r.Steps {
+		w.Write([]string{
+			strconv.Itoa(int(step.At.Seconds())),
+			strconv.FormatFloat(step.Requests, 'f', -1, 64),
+			strconv.Itoa(step.Instances),
+			strconv.Itoa(step.Starting),
+			strconv.Itoa(step.Idle),
+			strconv.Itoa(step.Started),
+			strconv.Itoa(step.ColdStarts),
+			strconv.FormatFloat(step.Queued, 'f', 1, 64),
+			strconv.FormatInt(step.PendingLatency.Milliseconds(), 10),
+		})
+	}
+	w.Flush()
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
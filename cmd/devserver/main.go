@@ -0,0 +1,152 @@
+// Runs service-feed and service-user in one process, with the feed calling
+// the user handlers directly and an in-memory store instead of Datastore, for
+// working on the frontend or the simulator offline.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+
+	"holosam/appengine/demo/pkg/database"
+	"holosam/appengine/demo/pkg/features"
+	"holosam/appengine/demo/pkg/feedsvc"
+	"holosam/appengine/demo/pkg/logging"
+	"holosam/appengine/demo/pkg/secrets"
+	"holosam/appengine/demo/pkg/userclient"
+	"holosam/appengine/demo/pkg/usersvc"
+	"holosam/appengine/demo/pkg/util"
+)
+
+var (
+	feedPort   = flag.Int("feed-port", 8080, "Port for service-feed.")
+	userPort   = flag.Int("user-port", 0, "Port for service-user's HTTP API. If 0, it's served on the feed port under -user-prefix.")
+	userPrefix = flag.String("user-prefix", "/svc/user", "Path prefix for service-user's HTTP API when it shares the feed port.")
+	dataFile   = flag.String("data", "", "JSON file to load the store from and save it to. If empty, nothing is kept after exit.")
+	seedUsers  = flag.Int("seed", 0, "Users to create at startup, named user1 to userN, if user1 doesn't exist yet.")
+	seedDocs   = flag.Int("seed-docs", 3, "Docs to publish for each seeded user.")
+
+	logger = logging.New("devserver")
+
+	shutdownTimeout = 5 * time.Second
+)
+
+// The same variables as the services, apart from the ones for Datastore and
+// reaching service-user. Flags can only come from a file.
+type config struct {
+	Tracing util.TracingConfig
+	Feed    feedsvc.Config
+	Flags   features.Config
+	Secrets secrets.Config
+}
+
+func main() {
+	flag.Parse()
+	logging.RedirectStdLog(logger)
+
+	ctx := context.Background()
+	var cfg config
+	report, err := util.LoadConfig(&cfg)
+	if err != nil {
+		logger.Fatalf(ctx, "%v", err)
+	}
+
+	flushTraces := util.InitTracing("devserver", cfg.Tracing)
+
+	var store *database.MemoryStore
+	if *dataFile != "" {
+		if store, err = database.OpenFileStore(*dataFile); err != nil {
+			logger.Fatalf(ctx, "Failed to open store: %v", err)
+		}
+	} else {
+		store = database.NewMemoryStore()
+	}
+
+	// The feed calls the user handlers directly, so there's nothing to sign.
+	users := usersvc.New(store, nil)
+	if *seedUsers > 0 {
+		if err := seed(ctx, store, users, *seedUsers, *seedDocs); err != nil {
+			logger.Fatalf(ctx, "Failed to seed store: %v", err)
+		}
+	}
+
+	var source features.Source
+	switch cfg.Flags.Source {
+	case features.SourceFile:
+		source = features.FileSource(cfg.Flags.File)
+	case features.SourceDatastore:
+		logger.Fatalf(ctx, "FLAGS_SOURCE datastore doesn't work without Datastore, use a file")
+	}
+	flags := features.New(source, feedsvc.FlagDefinitions(cfg.Feed)...)
+	if err := flags.Reload(ctx); err != nil {
+		logger.Warningf(ctx, "Starting with default flags: %v", err)
+	}
+	go flags.Watch(ctx, cfg.Flags.Refresh)
+
+	secretStore, err := secrets.Open(cfg.Secrets)
+	if err != nil {
+		logger.Fatalf(ctx, "Failed to open secrets: %v", err)
+	}
+	flashKey, err := secretStore.Optional(ctx, util.EnvFlashKey)
+	if err != nil {
+		logger.Fatalf(ctx, "Failed to read %s: %v", util.EnvFlashKey, err)
+	}
+	flash := util.NewFlashStore(flashKey)
+	secretStore.OnRotate(util.EnvFlashKey, flash.SetKey)
+	go secretStore.Watch(ctx, cfg.Secrets.Refresh)
+
+	feed := feedsvc.New(cfg.Feed, store, userclient.NewLocal(users), flags, flash)
+
+	feedRouter := newRouter(store)
+	feed.Register(feedRouter)
+	// Unlike the real services, these aren't behind ADMIN_TOKEN, since the dev
+	// server is for local work.
+	feedRouter.HandleFunc(http.MethodGet, "/debug/config", util.ConfigHandler(report))
+	feedRouter.HandleFunc(http.MethodGet, "/debug/flags", flags.Handler)
+	feedServer := util.NewHttpServer(*feedPort, feedRouter)
+
+	// The user server has to be done with the store before the feed's cleanup
+	// closes it.
+	userDone := make(chan struct{})
+	if *userPort == 0 {
+		users.Register(feedRouter.Group(*userPrefix))
+		close(userDone)
+		logger.Infof(ctx, "Serving feed on :%d and user on :%d%s", *feedPort, *feedPort, *userPrefix)
+	} else {
+		userRouter := newRouter(store)
+		users.Register(userRouter)
+		userServer := util.NewHttpServer(*userPort, userRouter)
+		go func() {
+			defer close(userDone)
+			if err := util.ListenAndServe(userServer, shutdownTimeout); err != nil && err != http.ErrServerClosed {
+				logger.Fatalf(ctx, "User server error: %v", err)
+			}
+		}()
+		logger.Infof(ctx, "Serving feed on :%d and user on :%d", *feedPort, *userPort)
+	}
+
+	err = util.ListenAndServe(feedServer, shutdownTimeout, func(ctx context.Context) error {
+		select {
+		case <-userDone:
+			return nil
+		case <-ctx.Done():
+			return fmt.Errorf("user server didn't stop: %w", ctx.Err())
+		}
+	}, store.Close, flushTraces)
+	if err != nil && err != http.ErrServerClosed {
+		logger.Fatalf(ctx, "Feed server error: %v", err)
+	}
+}
+
+// The same health and metrics routes as the real services.
+func newRouter(store database.Store) *util.Router {
+	router := util.NewRouter()
+	router.HandleFunc(http.MethodGet, "/metrics", util.MetricsHandler)
+	router.HandleFunc(http.MethodGet, "/healthz", util.HealthzHandler)
+	router.HandleFunc(http.MethodGet, "/readyz", util.ReadyzHandler(map[string]util.HealthCheck{
+		"store": store.Ping,
+	}))
+	return router
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+
+	"holosam/appengine/demo/pkg/database"
+	"holosam/appengine/demo/pkg/usersvc"
+	"holosam/appengine/demo/pkg/util"
+)
+
+// How many others each seeded user follows, at most.
+const seedFollows = 3
+
+// Goes through the user handlers, so the data looks the same as if it came in
+// through the app.
+func seed(ctx context.Context, store database.Store, users *usersvc.Handler, n, docs int) error {
+	if _, err := store.GetUser(ctx, seedName(1)); err == nil {
+		logger.Infof(ctx, "Store already has %s, not seeding", seedName(1))
+		return nil
+	} else if !errors.Is(err, util.KindNotFound) {
+		return err
+	}
+
+	for i := 1; i <= n; i++ {
+		name := seedName(i)
+		err := store.ModifyUser(ctx, name, func(u *database.User) {}, func() (database.User, error) {
+			return database.NewUser(name), nil
+		})
+		if err != nil {
+			return fmt.Errorf("create %s error: %w", name, err)
+		}
+
+		for j := 1; j <= docs; j++ {
+			_, err := users.Publish(ctx, &database.PublishRequest{
+				User: name,
+				Text: fmt.Sprintf("Doc %d from %s", j, name),
+			})
+			if err != nil {
+				return fmt.Errorf("publish for %s error: %w", name, err)
+			}
+		}
+	}
+
+	for i := 1; i <= n && n > 1; i++ {
+		for j := 0; j < seedFollows; j++ {
+			dst := rand.Intn(n) + 1
+			if dst == i {
+				continue
+			}
+			_, err := users.Follow(ctx, &database.FollowRequest{Src: seedName(i), Dst: seedName(dst)})
+			if err != nil {
+				return fmt.Errorf("follow for %s error: %w", seedName(i), err)
+			}
+		}
+	}
+
+	logger.Infof(ctx, "Seeded %d users with %d docs each", n, docs)
+	return nil
+}
+
+func seedName(i int) string {
+	return fmt.Sprintf("user%d", i)
+}
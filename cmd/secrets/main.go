@@ -0,0 +1,145 @@
+// Manages the encrypted secrets file that SECRETS_BACKEND=encrypted reads.
+//
+//	go run ./cmd/secrets keygen > secrets.key
+//	export SECRETS_FILE=secrets.enc SECRETS_KEY_FILE=secrets.key
+//	head -c 32 /dev/urandom | base64 | go run ./cmd/secrets set FLASH_KEY
+//	go run ./cmd/secrets list
+//	go run ./cmd/secrets get FLASH_KEY
+//	go run ./cmd/secrets delete FLASH_KEY
+//	echo "$TOKEN" | go run ./cmd/secrets token ADMIN_TOKEN
+//
+// The file and key come from the same variables as the services, or -file and
+// -key-file. set reads the value from stdin, without a trailing newline. token
+// does too, and stores its digest under the file's TOKEN_PEPPER instead, see
+// util.AdminToken.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"holosam/appengine/demo/pkg/secrets"
+	"holosam/appengine/demo/pkg/util"
+)
+
+func main() {
+	file := flag.String("file", os.Getenv("SECRETS_FILE"), "The encrypted secrets file")
+	keyFile := flag.String("key-file", os.Getenv("SECRETS_KEY_FILE"), "File with the key. SECRETS_KEY is used if this is empty.")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: secrets [flags] keygen | list | get NAME | set NAME | token NAME | delete NAME")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(flag.Args(), *file, *keyFile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, file, keyFile string) error {
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if args[0] == "keygen" {
+		key, err := secrets.GenerateKey()
+		if err != nil {
+			return err
+		}
+		fmt.Println(key)
+		return nil
+	}
+
+	if file == "" {
+		return fmt.Errorf("no secrets file, set SECRETS_FILE or -file")
+	}
+	encoded := os.Getenv("SECRETS_KEY")
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return fmt.Errorf("read key error: %w", err)
+		}
+		encoded = string(data)
+	}
+	if encoded == "" {
+		return fmt.Errorf("no key, set SECRETS_KEY_FILE, SECRETS_KEY or -key-file")
+	}
+	key, err := secrets.ParseKey(encoded)
+	if err != nil {
+		return err
+	}
+
+	// Only set makes a new file. Anything else on a missing one is more
+	// likely the wrong path.
+	values, err := secrets.ReadEncrypted(file, key)
+	if errors.Is(err, os.ErrNotExist) && (args[0] == "set" || args[0] == "token") {
+		values = make(map[string]string)
+	} else if err != nil {
+		return err
+	}
+
+	cmd, names := args[0], args[1:]
+	switch {
+	case cmd == "list" && len(names) == 0:
+		list := make([]string, 0, len(values))
+		for name := range values {
+			list = append(list, name)
+		}
+		sort.Strings(list)
+		for _, name := range list {
+			fmt.Println(name)
+		}
+		return nil
+	case cmd == "get" && len(names) == 1:
+		v, ok := values[names[0]]
+		if !ok {
+			return fmt.Errorf("no secret %s in %s", names[0], file)
+		}
+		fmt.Println(v)
+		return nil
+	case cmd == "set" && len(names) == 1:
+		value, err := readValue(names[0])
+		if err != nil {
+			return err
+		}
+		values[names[0]] = value
+	case cmd == "token" && len(names) == 1:
+		pepper, ok := values[util.EnvTokenPepper]
+		if !ok {
+			return fmt.Errorf("no %s in %s, set it first", util.EnvTokenPepper, file)
+		}
+		token, err := readValue(names[0])
+		if err != nil {
+			return err
+		}
+		values[names[0]] = util.PepperToken([]byte(pepper), token)
+	case cmd == "delete" && len(names) == 1:
+		if _, ok := values[names[0]]; !ok {
+			return fmt.Errorf("no secret %s in %s", names[0], file)
+		}
+		delete(values, names[0])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	return secrets.WriteEncrypted(file, key, values)
+}
+
+// From stdin, without a trailing newline.
+func readValue(name string) (string, error) {
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("read value error: %w", err)
+	}
+	value := strings.TrimRight(string(data), "\r\n")
+	if value == "" {
+		return "", fmt.Errorf("no value on stdin for %s", name)
+	}
+	return value, nil
+}
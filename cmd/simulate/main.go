@@ -7,6 +7,8 @@ import (
 	"os"
 	"strconv"
 	"time"
+
+	"holosam/appengine/demo/pkg/util"
 )
 
 var (
@@ -16,19 +18,29 @@ var (
 )
 
 var (
-	feedURL     = flag.String("url", "", "Base url for the web app.")
+	feedURL     = flag.String("url", "", "Base url for the web app. Defaults to the feed endpoint from the environment, see util.Endpoints.")
 	simDuration = flag.Duration("d", 30*time.Minute, "How long to run for.")
+	tracePath   = flag.String("trace", "", "Write the requests a second to this file, for cmd/autoscale.")
 )
 
 func main() {
 	flag.Parse()
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), *simDuration)
 	defer cancel()
 
 	if *feedURL == "" {
-		log.Fatalf("No input URL to hit.")
+		var cfg util.EndpointsConfig
+		if _, err := util.LoadConfig(&cfg); err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		endpoints, err := util.NewEndpoints(os.Getenv(util.EnvCloudProject), cfg)
+		if err != nil {
+			log.Fatalf("Failed to work out the feed URL: %v", err)
+		}
+		*feedURL = endpoints.BaseURL(util.ServiceFeed) + "/"
 	}
+	log.Printf("Simulating traffic to %s", *feedURL)
 
 	threadsStr := os.Getenv(envThreads)
 	threads, err := strconv.Atoi(threadsStr)
@@ -56,5 +68,19 @@ func main() {
 
 	sim.PrintStats()
 
+	if *tracePath != "" {
+		f, err := os.Create(*tracePath)
+		if err != nil {
+			log.Fatalf("Create trace error: %v", err)
+		}
+		if err := sim.WriteTrace(f); err != nil {
+			log.Fatalf("%v", err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatalf("Close trace error: %v", err)
+		}
+		log.Printf("Wrote trace to %s", *tracePath)
+	}
+
 	log.Printf("Exited successfully")
 }
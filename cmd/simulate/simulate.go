@@ -3,13 +3,16 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"net/url"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"holosam/appengine/demo/pkg/autoscale"
 	"holosam/appengine/demo/pkg/util"
 )
 
@@ -52,6 +55,15 @@ type Simulation struct {
 	mu      sync.RWMutex
 	metrics []map[reqType]*reqMetrics
 	rnd     *rand.Rand
+
+	// Requests by the second they started in, for the autoscaling model.
+	start time.Time
+	trace []traceSecond
+}
+
+type traceSecond struct {
+	requests int
+	latency  time.Duration
 }
 
 type SimParams struct {
@@ -65,14 +77,57 @@ type reqMetrics struct {
 	avgLatencyMs int
 	requests     int
 	errors       int
+
+	// Sums of the Server-Timing metrics, and how many responses had them.
+	timings      map[string]time.Duration
+	timedResults int
+}
+
+func (rm *reqMetrics) addTimings(timings map[string]time.Duration) {
+	if len(timings) == 0 {
+		return
+	}
+	if rm.timings == nil {
+		rm.timings = make(map[string]time.Duration)
+	}
+	for name, d := range timings {
+		rm.timings[name] += d
+	}
+	rm.timedResults++
+}
+
+// Average of each Server-Timing metric per timed request, like
+// "db=12.3ms pool=0.1ms total=20.5ms".
+func (rm *reqMetrics) timingSummary() string {
+	if rm.timedResults == 0 {
+		return "no Server-Timing data"
+	}
+
+	names := make([]string, 0, len(rm.timings))
+	for name := range rm.timings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		avg := rm.timings[name] / time.Duration(rm.timedResults)
+		parts[i] = fmt.Sprintf("%s=%.1fms", name, float64(avg)/float64(time.Millisecond))
+	}
+	return strings.Join(parts, " ")
 }
 
 func NewSimulation(params SimParams) *Simulation {
 	return &Simulation{
-		client:  util.NewHttpClient(),
+		// Every request should be measured as it went, not retried or cut off.
+		client: util.NewHttpClient(
+			util.WithRetryPolicy(util.RetryPolicy{MaxAttempts: 1}),
+			util.WithBreaker(util.BreakerConfig{}),
+		),
 		params:  params,
 		metrics: make([]map[reqType]*reqMetrics, params.MaxUserIndex),
 		rnd:     rand.New(rand.NewSource(time.Now().Unix())),
+		start:   time.Now(),
 	}
 }
 
@@ -94,7 +149,15 @@ func (s *Simulation) RunFlat(ctx context.Context) {
 	}
 
 	// Context is ended but still calls "Wait()"
-	pool.Join(ctx)
+	joinPool(ctx, pool)
+}
+
+// The tasks don't return errors, so this is mostly the requests that were
+// still in flight when ctx ended.
+func joinPool(ctx context.Context, pool *util.ThreadPool) {
+	if err := pool.Join(ctx); err != nil {
+		log.Printf("Joined with %d requests outstanding: %v", pool.Outstanding(), err)
+	}
 }
 
 // Run a cyclical traffic pattern that goes up and down.
@@ -128,7 +191,7 @@ outer:
 			}
 			iteration++
 		}
-		pool.Join(cycleCtx)
+		joinPool(cycleCtx, pool)
 
 		if ascending && threadsToUse == s.params.Concurrency {
 			ascending = false
@@ -176,7 +239,7 @@ outer:
 			}
 			iteration++
 		}
-		pool.Join(cycleCtx)
+		joinPool(cycleCtx, pool)
 
 		if threadsToUse == 1 {
 			threadsToUse = s.params.Concurrency
@@ -208,11 +271,19 @@ func (s *Simulation) PrintStats() {
 			rm.requests += metrics.requests
 			rm.errors += metrics.errors
 			rm.avgLatencyMs = ((rm.avgLatencyMs * rm.requests) + (metrics.avgLatencyMs * metrics.requests)) / (rm.requests + metrics.requests)
+			for name, d := range metrics.timings {
+				if rm.timings == nil {
+					rm.timings = make(map[string]time.Duration)
+				}
+				rm.timings[name] += d
+			}
+			rm.timedResults += metrics.timedResults
 		}
 	}
 
 	for reqType, metrics := range totals {
-		fmt.Printf("Total - req %d: %+v\n", reqType, metrics)
+		fmt.Printf("Total - req %d: avgLatencyMs:%d requests:%d errors:%d\n", reqType, metrics.avgLatencyMs, metrics.requests, metrics.errors)
+		fmt.Printf("  Server-Timing avg: %s\n", metrics.timingSummary())
 	}
 }
 
@@ -242,7 +313,7 @@ func (s *Simulation) executeEvent(userIndex int) {
 
 func (s *Simulation) recordRequest(t reqType, userIndex int, url string) {
 	startTime := time.Now()
-	_, err := s.client.Send(util.ReqOpts{
+	_, header, err := s.client.SendWithHeader(context.Background(), util.ReqOpts{
 		Method: "GET",
 		Url:    url,
 	})
@@ -264,6 +335,38 @@ func (s *Simulation) recordRequest(t reqType, userIndex int, url string) {
 	if err != nil {
 		rm.errors++
 	}
+	// The time on the instance, if the server said, is what autoscaling sees.
+	latency := time.Since(startTime)
+	if header != nil {
+		timings := util.ParseServerTiming(header.Values(util.HeaderServerTiming))
+		rm.addTimings(timings)
+		if total, ok := timings[util.TimingTotal]; ok {
+			latency = total
+		}
+	}
+
+	second := int(startTime.Sub(s.start).Seconds())
+	for len(s.trace) <= second {
+		s.trace = append(s.trace, traceSecond{})
+	}
+	s.trace[second].requests++
+	s.trace[second].latency += latency
+}
+
+// Writes the requests a second and their average latency as a CSV trace for
+// cmd/autoscale.
+func (s *Simulation) WriteTrace(w io.Writer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	trace := make(autoscale.Trace, len(s.trace))
+	for i, sec := range s.trace {
+		trace[i].Requests = float64(sec.requests)
+		if sec.requests > 0 {
+			trace[i].Latency = sec.latency / time.Duration(sec.requests)
+		}
+	}
+	return autoscale.WriteTrace(w, trace)
 }
 
 func username(userIndex int) string {
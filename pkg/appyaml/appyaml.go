@@ -0,0 +1,90 @@
+// Reads App Engine app.yaml files, keeping line numbers so problems can point
+// at the line to fix.
+package appyaml
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type File struct {
+	Path string
+	// The top level mapping.
+	Root *yaml.Node
+
+	lines []string
+}
+
+// A key and scalar value, with the line of the key.
+type Entry struct {
+	Key   string
+	Value string
+	Line  int
+}
+
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read app.yaml error: %w", err)
+	}
+	return Parse(path, data)
+}
+
+func Parse(path string, data []byte) (*File, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	if len(doc.Content) > 0 {
+		root = doc.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s:%d: top level should be a mapping", path, root.Line)
+	}
+	return &File{Path: path, Root: root, lines: strings.Split(string(data), "\n")}, nil
+}
+
+// The value for key in a mapping node, or nil.
+func Lookup(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// The entries of a mapping of scalars, in file order. Anything that isn't a
+// scalar is an error.
+func Entries(path string, mapping *yaml.Node) ([]Entry, error) {
+	if mapping == nil {
+		return nil, nil
+	}
+	if mapping.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s:%d: should be a mapping", path, mapping.Line)
+	}
+
+	entries := make([]Entry, 0, len(mapping.Content)/2)
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		key, value := mapping.Content[i], mapping.Content[i+1]
+		if value.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("%s:%d: %s should be a single value", path, key.Line, key.Value)
+		}
+		entries = append(entries, Entry{Key: key.Value, Value: value.Value, Line: key.Line})
+	}
+	return entries, nil
+}
+
+// The env_variables block. Values are as written, so 10 and "10" are both
+// "10", like App Engine sets them.
+func (f *File) EnvVariables() ([]Entry, error) {
+	return Entries(f.Path, Lookup(f.Root, "env_variables"))
+}
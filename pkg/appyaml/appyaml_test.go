@@ -0,0 +1,74 @@
+package appyaml
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEnvVariables(t *testing.T) {
+	f, err := Parse("app.yaml", []byte(`runtime: go122
+env_variables:
+  # Comment
+  HEADLINE: "Welcome"
+  MAX_THREADS: 10
+  INCLUDE_FOLLOWERS: true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := f.EnvVariables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{
+		{Key: "HEADLINE", Value: "Welcome", Line: 4},
+		{Key: "MAX_THREADS", Value: "10", Line: 5},
+		{Key: "INCLUDE_FOLLOWERS", Value: "true", Line: 6},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+
+	f, err = Parse("app.yaml", []byte("env_variables:\n  HOSTS:\n  - a\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.EnvVariables(); err == nil || err.Error() != "app.yaml:2: HOSTS should be a single value" {
+		t.Errorf("Got %v, want a line-numbered error", err)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	feed, err := Parse("feed.yaml", []byte(`instance_class: F1
+automatic_scaling:
+  target_throughput_utilization: 0.60
+  # max_concurrent_requests: 10
+  max_pending_latency: 300ms
+env_variables:
+  MAX_THREADS: 10
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := Parse("user.yaml", []byte(`instance_class: F1
+automatic_scaling:
+  target_throughput_utilization: 0.6
+  max_concurrent_requests: 10
+  max_pending_latency: 0.3s
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Row{
+		{Key: "automatic_scaling.max_concurrent_requests", Values: []*Setting{{Value: "10", Line: 4, Commented: true}, {Value: "10", Line: 4}}, Differs: true},
+		{Key: "automatic_scaling.max_pending_latency", Values: []*Setting{{Value: "300ms", Line: 5}, {Value: "300ms", Line: 5}}},
+		{Key: "automatic_scaling.target_throughput_utilization", Values: []*Setting{{Value: "0.6", Line: 3}, {Value: "0.6", Line: 3}}},
+		{Key: "env_variables.MAX_THREADS", Values: []*Setting{{Value: "10", Line: 7}, nil}, Differs: true},
+		{Key: "instance_class", Values: []*Setting{{Value: "F1", Line: 1}, {Value: "F1", Line: 1}}},
+	}
+	if got := Compare(feed.Settings(), user.Settings()); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,150 @@
+package appyaml
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The blocks Settings reads, flattened to keys like
+// automatic_scaling.max_instances.
+var settingBlocks = []string{"automatic_scaling", "basic_scaling", "manual_scaling", "env_variables"}
+
+// A key written in a comment, like "  # max_concurrent_requests: 10".
+var commentedKey = regexp.MustCompile(`^\s+#\s*([A-Za-z_][A-Za-z0-9_]*):\s*(.*?)\s*$`)
+
+type Setting struct {
+	Value string `json:"value"`
+	Line  int    `json:"line,omitempty"`
+	// Only in a comment, so App Engine doesn't see it.
+	Commented bool `json:"commented,omitempty"`
+}
+
+func (s *Setting) set() bool {
+	return s != nil && !s.Commented
+}
+
+func (s *Setting) String() string {
+	switch {
+	case s == nil:
+		return "-"
+	case s.Commented:
+		return "# " + s.Value
+	}
+	return s.Value
+}
+
+// Settings by key: instance_class, then the scaling and env_variables blocks
+// as block.key.
+type Settings map[string]*Setting
+
+// The scaling and env settings, including ones commented out inside those
+// blocks. Scaling numbers and latencies are normalized, so 0.60 is 0.6 and
+// 0.300s is 300ms.
+func (f *File) Settings() Settings {
+	settings := make(Settings)
+	if class := Lookup(f.Root, "instance_class"); class != nil {
+		settings["instance_class"] = &Setting{Value: class.Value, Line: class.Line}
+	}
+
+	for _, block := range settingBlocks {
+		node := Lookup(f.Root, block)
+		if node == nil || node.Kind != yaml.MappingNode {
+			continue
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if value.Kind != yaml.ScalarNode {
+				continue
+			}
+			settings.add(block, key.Value, &Setting{Value: value.Value, Line: key.Line})
+		}
+
+		start, end := f.blockLines(block)
+		for line := start; line < end; line++ {
+			m := commentedKey.FindStringSubmatch(f.lines[line-1])
+			if m == nil || settings[block+"."+m[1]] != nil {
+				continue
+			}
+			settings.add(block, m[1], &Setting{Value: strings.Trim(m[2], `"'`), Line: line, Commented: true})
+		}
+	}
+	return settings
+}
+
+func (s Settings) add(block, key string, setting *Setting) {
+	if block != "env_variables" {
+		setting.Value = NormalizeValue(setting.Value)
+	}
+	s[block+"."+key] = setting
+}
+
+// The lines after a top level key and before the next one.
+func (f *File) blockLines(key string) (int, int) {
+	start, end := 0, len(f.lines)+1
+	for i := 0; i+1 < len(f.Root.Content); i += 2 {
+		k := f.Root.Content[i]
+		if k.Value == key {
+			start = k.Line + 1
+		} else if start > 0 && k.Line >= start {
+			end = k.Line
+			break
+		}
+	}
+	if start == 0 {
+		return 0, 0
+	}
+	return start, end
+}
+
+// Numbers and durations in one form, so the same setting written two ways
+// compares equal.
+func NormalizeValue(v string) string {
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return d.String()
+	}
+	return v
+}
+
+// A key across every column of a comparison. Values are nil where it isn't
+// there at all.
+type Row struct {
+	Key     string     `json:"key"`
+	Values  []*Setting `json:"values"`
+	Differs bool       `json:"differs"`
+}
+
+// Lines up the keys of every settings, sorted by key. A commented out setting
+// differs from a set one but not from a missing one.
+func Compare(columns ...Settings) []Row {
+	keys := make(map[string]bool)
+	for _, settings := range columns {
+		for key := range settings {
+			keys[key] = true
+		}
+	}
+
+	rows := make([]Row, 0, len(keys))
+	for key := range keys {
+		row := Row{Key: key, Values: make([]*Setting, len(columns))}
+		for i, settings := range columns {
+			row.Values[i] = settings[key]
+		}
+		first := row.Values[0]
+		for _, v := range row.Values[1:] {
+			if v.set() != first.set() || (v.set() && v.Value != first.Value) {
+				row.Differs = true
+			}
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Key < rows[j].Key })
+	return rows
+}
@@ -0,0 +1,346 @@
+// A model of App Engine standard's automatic scaling, to see what a change to
+// an automatic_scaling block does to instance counts, cold starts and queueing
+// before deploying it.
+//
+// It steps through a trace a second at a time. Each second the arriving
+// requests join a pending queue, ready instances serve what they can, and the
+// scheduler starts instances when the CPU or concurrency targets are passed or
+// the queue has waited longer than max_pending_latency. Instances take
+// Model.Startup to become ready and stop after Model.IdleTimeout idle, or right
+// away when there are more idle than max_idle_instances. It's a rough model,
+// good for comparing settings, not for predicting exact counts.
+package autoscale
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"holosam/appengine/demo/pkg/appyaml"
+)
+
+// Also stands for "automatic" in MaxIdleInstances.
+const Automatic = -1
+
+// An automatic_scaling block, with App Engine's defaults for what's missing.
+type Settings struct {
+	TargetCPU             float64
+	TargetThroughput      float64
+	MaxConcurrentRequests int
+	MinPendingLatency     time.Duration
+	MaxPendingLatency     time.Duration
+	MinIdleInstances      int
+	MaxIdleInstances      int
+	MinInstances          int
+	// 0 means no limit.
+	MaxInstances int
+}
+
+// How the model stands in for what App Engine doesn't document.
+type Model struct {
+	// From starting an instance to it serving.
+	Startup time.Duration
+	// How long an idle instance is kept when max_idle_instances allows it.
+	IdleTimeout time.Duration
+	// The fraction of a request's latency spent on the instance's CPU. The
+	// rest is waiting, like on Datastore.
+	CPUShare float64
+	// What "automatic" max_pending_latency is taken as.
+	AutomaticPendingLatency time.Duration
+}
+
+func DefaultSettings() Settings {
+	return Settings{
+		TargetCPU:             0.6,
+		TargetThroughput:      0.6,
+		MaxConcurrentRequests: 10,
+		MaxPendingLatency:     Automatic,
+		MaxIdleInstances:      Automatic,
+	}
+}
+
+func DefaultModel() Model {
+	return Model{
+		Startup:                 3 * time.Second,
+		IdleTimeout:             15 * time.Minute,
+		CPUShare:                0.5,
+		AutomaticPendingLatency: 30 * time.Millisecond,
+	}
+}
+
+// The automatic_scaling block of an app.yaml. Other scaling types aren't
+// modeled.
+func FromAppYAML(f *appyaml.File) (Settings, error) {
+	for _, key := range []string{"basic_scaling", "manual_scaling"} {
+		if appyaml.Lookup(f.Root, key) != nil {
+			return Settings{}, fmt.Errorf("%s: only automatic_scaling is modeled, not %s", f.Path, key)
+		}
+	}
+
+	s := DefaultSettings()
+	const prefix = "automatic_scaling."
+	for key, setting := range f.Settings() {
+		if !strings.HasPrefix(key, prefix) || setting.Commented {
+			continue
+		}
+		if err := s.Set(strings.TrimPrefix(key, prefix), setting.Value); err != nil {
+			return Settings{}, fmt.Errorf("%s:%d: %w", f.Path, setting.Line, err)
+		}
+	}
+	return s, nil
+}
+
+// Sets one automatic_scaling key, as app.yaml writes it.
+func (s *Settings) Set(key, value string) error {
+	var err error
+	switch key {
+	case "target_cpu_utilization":
+		s.TargetCPU, err = parseFraction(value)
+	case "target_throughput_utilization":
+		s.TargetThroughput, err = parseFraction(value)
+	case "max_concurrent_requests":
+		s.MaxConcurrentRequests, err = parseCount(value, false)
+		if err == nil && s.MaxConcurrentRequests < 1 {
+			err = fmt.Errorf("should be at least 1")
+		}
+	case "min_pending_latency":
+		s.MinPendingLatency, err = parseLatency(value)
+	case "max_pending_latency":
+		s.MaxPendingLatency, err = parseLatency(value)
+	case "min_idle_instances":
+		s.MinIdleInstances, err = parseCount(value, false)
+	case "max_idle_instances":
+		s.MaxIdleInstances, err = parseCount(value, true)
+	case "min_instances":
+		s.MinInstances, err = parseCount(value, false)
+	case "max_instances":
+		s.MaxInstances, err = parseCount(value, false)
+	default:
+		return fmt.Errorf("unknown automatic_scaling key %s", key)
+	}
+	if err != nil {
+		return fmt.Errorf("%s %q: %w", key, value, err)
+	}
+	return nil
+}
+
+func parseFraction(value string) (float64, error) {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || v <= 0 || v > 1 {
+		return 0, fmt.Errorf("should be a number above 0 and up to 1")
+	}
+	return v, nil
+}
+
+func parseCount(value string, automatic bool) (int, error) {
+	if automatic && value == "automatic" {
+		return Automatic, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("should be a whole number")
+	}
+	return v, nil
+}
+
+func parseLatency(value string) (time.Duration, error) {
+	if value == "automatic" {
+		return Automatic, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("should be a duration like 300ms")
+	}
+	return d, nil
+}
+
+// One second of the simulation.
+type Step struct {
+	At       time.Duration
+	Requests float64
+	// Serving, including idle ones.
+	Instances int
+	Starting  int
+	Idle      int
+	Started   int
+	// Instances started while requests were waiting for them.
+	ColdStarts int
+	// Requests still waiting at the end of the second.
+	Queued         float64
+	PendingLatency time.Duration
+}
+
+type Result struct {
+	Steps []Step
+
+	Requests float64
+	// Billed from the start of an instance to its stop.
+	InstanceHours float64
+	PeakInstances int
+	Started       int
+	ColdStarts    int
+	// The average time a request spent in the pending queue.
+	MeanQueueDelay    time.Duration
+	MaxPendingLatency time.Duration
+	// Seconds where the queue waited longer than max_pending_latency.
+	SlowSeconds int
+}
+
+type instance struct {
+	readyAt   int
+	idleSince int
+}
+
+// Runs the trace through the settings. Requests still queued at the end of the
+// trace are dropped.
+func Simulate(s Settings, m Model, trace Trace) Result {
+	maxPending := s.MaxPendingLatency
+	if maxPending == Automatic {
+		maxPending = m.AutomaticPendingLatency
+	}
+	minPending := s.MinPendingLatency
+	if minPending == Automatic {
+		minPending = 0
+	}
+	startup := int(math.Ceil(m.Startup.Seconds()))
+
+	var (
+		result   Result
+		ready    []*instance
+		starting []*instance
+		queue    float64
+		// Seconds the queue has been waiting with nothing to serve it.
+		stalled     int
+		queuedTotal float64
+	)
+	for t, p := range trace {
+		// Starting instances become ready in the order they were started.
+		for len(starting) > 0 && starting[0].readyAt <= t {
+			starting[0].idleSince = t
+			ready = append(ready, starting[0])
+			starting = starting[1:]
+		}
+
+		latency := math.Max(p.Latency.Seconds(), 0.001)
+		// Requests a second one instance can serve, limited by whichever of
+		// concurrency and CPU runs out first.
+		perInstance := math.Min(float64(s.MaxConcurrentRequests)/latency, 1/(latency*m.CPUShare))
+		capacity := float64(len(ready)) * perInstance
+
+		queue += p.Requests
+		served := math.Min(queue, capacity)
+		queue -= served
+
+		var pending time.Duration
+		switch {
+		case queue <= 0:
+			stalled = 0
+		case capacity > 0:
+			stalled = 0
+			pending = seconds(queue / capacity)
+		default:
+			stalled++
+			pending = time.Duration(stalled) * time.Second
+		}
+
+		// Instances to keep for the load, by CPU and by concurrency.
+		busy := math.Max(
+			p.Requests*latency*m.CPUShare/s.TargetCPU,
+			p.Requests*latency/(float64(s.MaxConcurrentRequests)*s.TargetThroughput),
+		)
+		load := int(math.Ceil(busy - 1e-9))
+		want := load
+		if queue > 0 && pending > maxPending && pending >= minPending {
+			// Enough more to clear the queue within a second, counting the
+			// ones already starting.
+			want = max(want, len(ready)+max(len(starting), int(math.Ceil(queue/perInstance))))
+		}
+		want = max(want, s.MinInstances) + s.MinIdleInstances
+		if s.MaxInstances > 0 {
+			want = min(want, s.MaxInstances)
+		}
+
+		step := Step{At: time.Duration(t) * time.Second, Requests: p.Requests}
+		// A queue waiting under min_pending_latency doesn't start anything.
+		if n := want - len(ready) - len(starting); n > 0 && !(queue > 0 && pending < minPending) {
+			for i := 0; i < n; i++ {
+				starting = append(starting, &instance{readyAt: t + startup})
+			}
+			step.Started = n
+			if queue > 0 {
+				step.ColdStarts = n
+			}
+		}
+
+		// The first instances serve the load and the rest are idle.
+		inUse := min(load, len(ready))
+		for _, inst := range ready[:inUse] {
+			inst.idleSince = t
+		}
+		ready = stopIdle(s, m, ready, inUse, want, t)
+
+		step.Instances = len(ready)
+		step.Starting = len(starting)
+		step.Idle = len(ready) - inUse
+		step.Queued = queue
+		step.PendingLatency = pending
+		result.Steps = append(result.Steps, step)
+
+		result.Requests += p.Requests
+		result.InstanceHours += float64(len(ready)+len(starting)) / 3600
+		result.PeakInstances = max(result.PeakInstances, len(ready)+len(starting))
+		result.Started += step.Started
+		result.ColdStarts += step.ColdStarts
+		if pending > result.MaxPendingLatency {
+			result.MaxPendingLatency = pending
+		}
+		if pending > maxPending {
+			result.SlowSeconds++
+		}
+		queuedTotal += queue
+	}
+
+	if result.Requests > 0 {
+		result.MeanQueueDelay = seconds(queuedTotal / result.Requests)
+	}
+	return result
+}
+
+// Stops idle instances over max_idle_instances, and ones idle longer than the
+// timeout, but never below the keep the scheduler wants.
+func stopIdle(s Settings, m Model, ready []*instance, inUse, keep, t int) []*instance {
+	maxIdle := len(ready)
+	if s.MaxIdleInstances != Automatic {
+		maxIdle = max(s.MaxIdleInstances, s.MinIdleInstances)
+	}
+	timeout := int(m.IdleTimeout.Seconds())
+
+	kept := ready[:inUse]
+	for _, inst := range ready[inUse:] {
+		idle := len(kept) - inUse
+		if len(kept) < keep || (idle < maxIdle && t-inst.idleSince < timeout) {
+			kept = append(kept, inst)
+		}
+	}
+	return kept
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,69 @@
+package autoscale
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulate(t *testing.T) {
+	trace, err := Shape("flat", 5*time.Minute, 50, 100*time.Millisecond, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 50 requests a second at half of 100ms on CPU needs 2.5 CPUs, so 5
+	// instances to stay under 60%.
+	// The instances started for the first queue would otherwise stay idle.
+	s := DefaultSettings()
+	s.MaxIdleInstances = 0
+	result := Simulate(s, DefaultModel(), trace)
+	last := result.Steps[len(result.Steps)-1]
+	if last.Instances != 5 || last.Queued != 0 {
+		t.Errorf("Got %d instances and %v queued, want 5 and none", last.Instances, last.Queued)
+	}
+
+	// 2 instances only serve 40 a second, so the queue keeps growing.
+	s.MaxInstances = 2
+	result = Simulate(s, DefaultModel(), trace)
+	last = result.Steps[len(result.Steps)-1]
+	if last.Instances != 2 || last.Queued < 2000 || result.PeakInstances != 2 {
+		t.Errorf("Got %d instances and %v queued, want 2 and a growing queue", last.Instances, last.Queued)
+	}
+}
+
+func TestReadTrace(t *testing.T) {
+	got, err := ReadTrace(strings.NewReader("second,requests,latency_ms\n0,10,20\n2,5,12.5\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Trace{
+		{Requests: 10, Latency: 20 * time.Millisecond},
+		{},
+		{Requests: 5, Latency: 12500 * time.Microsecond},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	f1 := Class{Hourly: 0.05, CPUMHz: 600, FreeHoursPerDay: 28}
+	f2 := Class{Hourly: 0.10, CPUMHz: 1200, FreeHoursPerDay: 14}
+
+	// Half of 100ms on a CPU twice as fast is 75ms, a third of it on CPU.
+	m, trace := OnClass(DefaultModel(), Trace{{Requests: 1, Latency: 100 * time.Millisecond}}, f1, f2)
+	if trace[0].Latency != 75*time.Millisecond || m.CPUShare != 1.0/3 {
+		t.Errorf("Got %v and %v on CPU, want 75ms and a third", trace[0].Latency, m.CPUShare)
+	}
+
+	// 2 instances all day is 1440 hours a month, and 840 of them are free.
+	result := Result{Steps: make([]Step, 24*3600), InstanceHours: 48}
+	got := f1.Estimate(result)
+	want := Estimate{InstanceHours: 48, Cost: 2.4, MonthlyHours: 1440, MonthlyCost: 30}
+	if math.Abs(got.Cost-want.Cost) > 1e-9 || math.Abs(got.MonthlyCost-want.MonthlyCost) > 1e-9 || got.MonthlyHours != want.MonthlyHours {
+		t.Errorf("Got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,93 @@
+package autoscale
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// What an instance class costs and how fast it is.
+type Class struct {
+	Hourly float64 `yaml:"hourly"`
+	CPUMHz float64 `yaml:"cpu_mhz"`
+	// Hours of this class a day that aren't billed.
+	FreeHoursPerDay float64 `yaml:"free_hours_per_day"`
+}
+
+type Prices struct {
+	Currency string           `yaml:"currency"`
+	Region   string           `yaml:"region"`
+	Classes  map[string]Class `yaml:"classes"`
+}
+
+func LoadPrices(path string) (*Prices, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read prices error: %w", err)
+	}
+
+	var p Prices
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(p.Classes) == 0 {
+		return nil, fmt.Errorf("%s has no classes", path)
+	}
+	for name, c := range p.Classes {
+		if c.Hourly < 0 || c.CPUMHz <= 0 || c.FreeHoursPerDay < 0 {
+			return nil, fmt.Errorf("%s: class %s needs an hourly price and cpu_mhz", path, name)
+		}
+	}
+	return &p, nil
+}
+
+func (p *Prices) Class(name string) (Class, error) {
+	c, ok := p.Classes[name]
+	if !ok {
+		return Class{}, fmt.Errorf("no price for instance class %s", name)
+	}
+	return c, nil
+}
+
+// The model and trace as they'd be on a class with a different CPU. Only the
+// time on the CPU changes, not time spent waiting.
+func OnClass(m Model, trace Trace, from, to Class) (Model, Trace) {
+	ratio := from.CPUMHz / to.CPUMHz
+	// Per second of latency on the old class.
+	cpu := m.CPUShare * ratio
+	scale := cpu + 1 - m.CPUShare
+
+	m.CPUShare = cpu / scale
+	scaled := make(Trace, len(trace))
+	for i, p := range trace {
+		scaled[i] = Point{Requests: p.Requests, Latency: time.Duration(float64(p.Latency) * scale)}
+	}
+	return m, scaled
+}
+
+type Estimate struct {
+	InstanceHours float64
+	// For the trace, before the free tier.
+	Cost float64
+	// As if the trace repeated for 30 days, after the free tier.
+	MonthlyHours float64
+	MonthlyCost  float64
+}
+
+func (c Class) Estimate(r Result) Estimate {
+	e := Estimate{InstanceHours: r.InstanceHours, Cost: r.InstanceHours * c.Hourly}
+	if len(r.Steps) == 0 {
+		return e
+	}
+
+	const month = 30 * 24 * time.Hour
+	length := time.Duration(len(r.Steps)) * time.Second
+	e.MonthlyHours = r.InstanceHours * float64(month) / float64(length)
+	billed := e.MonthlyHours - c.FreeHoursPerDay*30
+	if billed > 0 {
+		e.MonthlyCost = billed * c.Hourly
+	}
+	return e
+}
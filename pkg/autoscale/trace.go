@@ -0,0 +1,125 @@
+package autoscale
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+)
+
+// The requests that arrived in one second, and how long they took on the
+// instance.
+type Point struct {
+	Requests float64
+	Latency  time.Duration
+}
+
+// One point a second.
+type Trace []Point
+
+var traceHeader = []string{"second", "requests", "latency_ms"}
+
+// Reads a CSV trace, like cmd/simulate -trace writes. Seconds that are
+// missing had no requests.
+func ReadTrace(r io.Reader) (Trace, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("read trace error: %w", err)
+	}
+	if len(records) > 0 && records[0][0] == traceHeader[0] {
+		records = records[1:]
+	}
+
+	trace := make(Trace, 0, len(records))
+	for i, record := range records {
+		if len(record) != len(traceHeader) {
+			return nil, fmt.Errorf("trace line %d: should be second,requests,latency_ms", i+2)
+		}
+		second, err := strconv.Atoi(record[0])
+		if err != nil || second < len(trace) {
+			return nil, fmt.Errorf("trace line %d: second %q should be a whole number after the one before", i+2, record[0])
+		}
+		requests, err := strconv.ParseFloat(record[1], 64)
+		if err != nil || requests < 0 {
+			return nil, fmt.Errorf("trace line %d: requests %q should be a number", i+2, record[1])
+		}
+		latency, err := strconv.ParseFloat(record[2], 64)
+		if err != nil || latency < 0 {
+			return nil, fmt.Errorf("trace line %d: latency_ms %q should be a number", i+2, record[2])
+		}
+
+		for len(trace) < second {
+			trace = append(trace, Point{})
+		}
+		trace = append(trace, Point{Requests: requests, Latency: time.Duration(latency * float64(time.Millisecond))})
+	}
+	return trace, nil
+}
+
+func ReadTraceFile(path string) (Trace, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open trace error: %w", err)
+	}
+	defer f.Close()
+	return ReadTrace(f)
+}
+
+func WriteTrace(w io.Writer, trace Trace) error {
+	cw := csv.NewWriter(w)
+	cw.Write(traceHeader)
+	for i, p := range trace {
+		cw.Write([]string{
+			strconv.Itoa(i),
+			strconv.FormatFloat(p.Requests, 'f', -1, 64),
+			strconv.FormatFloat(float64(p.Latency)/float64(time.Millisecond), 'f', 3, 64),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("write trace error: %w", err)
+	}
+	return nil
+}
+
+// The traffic types of cmd/simulate, in requests a second:
+//   - flat: peak the whole time.
+//   - cyclical: up from a tenth of peak to peak and back down every period.
+//   - bursty: peak for 2 minutes, then a tenth of it for 2 to 8 minutes.
+func Shape(name string, length time.Duration, peak float64, latency time.Duration, period time.Duration) (Trace, error) {
+	n := int(length.Seconds())
+	trace := make(Trace, n)
+	low := peak / 10
+
+	switch name {
+	case "flat":
+		for i := range trace {
+			trace[i] = Point{Requests: peak, Latency: latency}
+		}
+	case "cyclical":
+		p := math.Max(period.Seconds(), 2)
+		for i := range trace {
+			// 0 to 1 and back over a period.
+			phase := 1 - math.Abs(2*math.Mod(float64(i), p)/p-1)
+			trace[i] = Point{Requests: low + (peak-low)*phase, Latency: latency}
+		}
+	case "bursty":
+		// The same bursts every run, so runs compare.
+		rnd := rand.New(rand.NewSource(1))
+		for i := 0; i < n; {
+			for end := min(i+120, n); i < end; i++ {
+				trace[i] = Point{Requests: peak, Latency: latency}
+			}
+			for end := min(i+120*(rnd.Intn(4)+1), n); i < end; i++ {
+				trace[i] = Point{Requests: low, Latency: latency}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown traffic shape %q, use flat, cyclical or bursty", name)
+	}
+	return trace, nil
+}
@@ -7,54 +7,112 @@ import (
 	"sync"
 	"time"
 
+	"holosam/appengine/demo/pkg/logging"
+	"holosam/appengine/demo/pkg/trace"
 	"holosam/appengine/demo/pkg/util"
 
 	"cloud.google.com/go/datastore"
 	"google.golang.org/api/option"
 )
 
-var (
-	includeFollowers = util.LoadEnvBool(util.EnvIncludeFollowers, false)
-	txnRetryStrat    = util.LoadEnvString(util.EnvTxnRetryStrat, "none")
-)
+var ErrUserNotFound = util.NewError(util.KindNotFound, "user not found", nil)
+
+var logger = logging.New("database")
+
+type Config struct {
+	ConnPoolSize  int    `env:"CONN_POOL_SIZE" default:"10" min:"1" max:"100"`
+	MaxThreads    int    `env:"MAX_THREADS" default:"10" min:"1" max:"1000"`
+	TxnRetryStrat string `env:"TXN_RETRY_STRAT" default:"none" oneof:"none|three|exp_backoff"`
+	// Read by the Datastore client itself, for running outside Google Cloud.
+	Credentials string `env:"GOOGLE_APPLICATION_CREDENTIALS"`
+}
 
 type DBClient struct {
-	client *datastore.Client
-	pool   *util.ThreadPool
-	ctx    context.Context
+	client   *datastore.Client
+	pool     *util.ThreadPool
+	connPool int
+	cfg      Config
 
 	mu  sync.Mutex
 	rnd *rand.Rand
 }
 
-func Init(ctx context.Context) (*DBClient, error) {
-	opt := option.WithGRPCConnectionPool(util.LoadEnvInt(util.EnvConnPoolSize, 10))
-	dbclient, err := datastore.NewClient(ctx, util.MustLoadEnvString(util.EnvCloudProject), opt)
+func Init(ctx context.Context, project string, cfg Config) (*DBClient, error) {
+	opt := option.WithGRPCConnectionPool(cfg.ConnPoolSize)
+	dbclient, err := datastore.NewClient(ctx, project, opt)
 	if err != nil {
 		return nil, err
 	}
 
 	return &DBClient{
-		client: dbclient,
-		pool:   util.NewThreadPool(util.LoadEnvInt(util.EnvMaxThreads, 10)),
-		ctx:    ctx,
-		rnd:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		client:   dbclient,
+		pool:     util.NewThreadPool(cfg.MaxThreads).Instrument("datastore"),
+		connPool: cfg.ConnPoolSize,
+		cfg:      cfg,
+		rnd:      rand.New(rand.NewSource(time.Now().UnixNano())),
 	}, err
 }
 
-func (d *DBClient) Close() {
-	d.pool.Join(d.ctx)
-	d.client.Close()
+// Waits for outstanding pool work until ctx ends, then closes the Datastore
+// client either way.
+func (d *DBClient) Close(ctx context.Context) error {
+	if err := d.pool.Join(ctx); err != nil {
+		logger.Warningf(ctx, "Closing db with %d pool tasks outstanding: %v", d.pool.Outstanding(), err)
+	}
+	return d.client.Close()
+}
+
+// Reads a user that doesn't exist, which only succeeds if Datastore is
+// reachable.
+func (d *DBClient) Ping(ctx context.Context) (err error) {
+	ctx, span := trace.Start(ctx, "DBClient.Ping")
+	defer func() { span.End(err) }()
+
+	var user User
+	stopTiming := util.StartTiming(ctx, util.TimingDB)
+	err = d.client.Get(ctx, datastore.NameKey(userTable, pingUser, nil), &user)
+	stopTiming()
+	if err == nil || err == datastore.ErrNoSuchEntity {
+		return nil
+	}
+	return err
+}
+
+// The connections in the pool are only dialed when first used, so send a ping
+// over each of them to take that latency before real traffic arrives.
+func (d *DBClient) Warmup(ctx context.Context) (err error) {
+	ctx, span := trace.Start(ctx, "DBClient.Warmup")
+	defer func() { span.End(err) }()
+
+	errs := make(chan error, d.connPool)
+	for i := 0; i < d.connPool; i++ {
+		go func() {
+			errs <- d.Ping(ctx)
+		}()
+	}
+
+	for i := 0; i < d.connPool; i++ {
+		if pingErr := <-errs; pingErr != nil && err == nil {
+			err = pingErr
+		}
+	}
+	return err
 }
 
-func (d *DBClient) ModifyUser(ctx context.Context, id string, modify func(u *User), create func() (User, error)) error {
+func (d *DBClient) ModifyUser(ctx context.Context, id string, modify func(u *User), create func() (User, error)) (err error) {
+	ctx, span := trace.Start(ctx, "DBClient.ModifyUser")
+	defer func() { span.End(err) }()
+	span.SetAttr("user", id)
+
 	key := datastore.NameKey(userTable, id, nil)
-	tries, waitTimeFunc := retryStrat(txnRetryStrat)
+	tries, waitTimeFunc := retryStrat(d.cfg.TxnRetryStrat)
 	for i := 0; i < tries; i++ {
-		err := d.pool.RunSync(ctx, func() error {
+		span.SetAttr("attempts", i+1)
+		err = d.pool.RunSync(ctx, func() error {
+			defer util.StartTiming(ctx, util.TimingDB)()
 			_, err := d.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
 				var user User
-				err := tx.Get(key, &user)
+				err := countOp(opGet, userTable, tx.Get(key, &user))
 				if err == nil {
 					modify(&user)
 				} else if err == datastore.ErrNoSuchEntity {
@@ -68,16 +126,21 @@ func (d *DBClient) ModifyUser(ctx context.Context, id string, modify func(u *Use
 				}
 
 				_, err = tx.Put(key, &user)
-				return err
+				return countOp(opPut, userTable, err)
 			})
-			return err
+			return countOp(opTransaction, userTable, err)
 		})
 
 		if err == nil {
 			// No retries needed.
-			break
+			return nil
 		} else if err == datastore.ErrConcurrentTransaction {
 			// Caller is supposed to retry this type of error.
+			txnConflicts.Inc(userTable)
+			if i+1 < tries {
+				txnRetries.Inc(userTable)
+				logger.Debugf(ctx, "Transaction conflict on user %s, retrying (attempt %d of %d)", id, i+2, tries)
+			}
 			time.Sleep(waitTimeFunc(i))
 		} else {
 			// Probably a real issue, best to break here.
@@ -85,16 +148,21 @@ func (d *DBClient) ModifyUser(ctx context.Context, id string, modify func(u *Use
 		}
 	}
 
-	return nil
+	return util.NewError(util.KindConflict, "too many concurrent changes, try again", err)
 }
 
-func (d *DBClient) WriteDocument(ctx context.Context, pr *PublishRequest) error {
+func (d *DBClient) WriteDocument(ctx context.Context, pr *PublishRequest) (_ *Document, err error) {
+	ctx, span := trace.Start(ctx, "DBClient.WriteDocument")
+	defer func() { span.End(err) }()
+	span.SetAttr("user", pr.User)
+
 	var doc Document
-	err := d.pool.RunSync(ctx, func() error {
+	err = d.pool.RunSync(ctx, func() error {
+		defer util.StartTiming(ctx, util.TimingDB)()
 		neededKeys := make([]*datastore.Key, 1)
 		neededKeys[0] = datastore.IncompleteKey(docsTable, nil)
 		allocKeys, err := d.client.AllocateIDs(ctx, neededKeys)
-		if err != nil {
+		if countOp(opAllocateIDs, docsTable, err) != nil {
 			return err
 		}
 
@@ -106,22 +174,38 @@ func (d *DBClient) WriteDocument(ctx context.Context, pr *PublishRequest) error
 		}
 
 		key := datastore.IDKey(docsTable, doc.ID, nil)
-		if _, err := d.client.Put(ctx, key, &doc); err != nil {
+		if _, err := d.client.Put(ctx, key, &doc); countOp(opPut, docsTable, err) != nil {
 			return fmt.Errorf("db put doc error for key %v: %v", key, err)
 		}
 		return nil
 	})
 
 	if err != nil {
-		return fmt.Errorf("write doc error: %v", err)
+		return nil, fmt.Errorf("write doc error: %v", err)
 	}
 
-	return d.ModifyUser(ctx, doc.Author, func(u *User) {
+	err = d.ModifyUser(ctx, doc.Author, func(u *User) {
 		u.AddDocument(doc.ID)
 	}, ErrNoUser)
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func (d *DBClient) GetUser(ctx context.Context, id string) (_ *User, err error) {
+	ctx, span := trace.Start(ctx, "DBClient.GetUser")
+	defer func() { span.End(err) }()
+	span.SetAttr("user", id)
+
+	return d.getUser(ctx, id)
 }
 
-func (d *DBClient) GetUserDocs(ctx context.Context, id string, n int) ([]*Document, error) {
+func (d *DBClient) GetUserDocs(ctx context.Context, id string, n int) (_ []*Document, err error) {
+	ctx, span := trace.Start(ctx, "DBClient.GetUserDocs")
+	defer func() { span.End(err) }()
+	span.SetAttr("user", id)
+
 	user, err := d.getUser(ctx, id)
 	if err != nil {
 		return nil, err
@@ -148,22 +232,32 @@ func (d *DBClient) GetUserDocs(ctx context.Context, id string, n int) ([]*Docume
 	d.mu.Unlock()
 
 	err = d.pool.RunSync(ctx, func() error {
-		return d.client.GetMulti(ctx, docKeys, docs)
+		defer util.StartTiming(ctx, util.TimingDB)()
+		return countOp(opGetMulti, docsTable, d.client.GetMulti(ctx, docKeys, docs))
 	})
 
 	return docs, err
 }
 
-func (d *DBClient) GetFollowingDocs(ctx context.Context, id string, n int) ([]*Document, error) {
+func (d *DBClient) GetFollowingDocs(ctx context.Context, id string, n int, opts FeedOptions) (_ []*Document, err error) {
+	ctx, span := trace.Start(ctx, "DBClient.GetFollowingDocs")
+	defer func() { span.End(err) }()
+	span.SetAttr("user", id)
+	span.SetAttr("algorithm", opts.Algorithm)
+
 	user, err := d.getUser(ctx, id)
 	if err != nil {
-		return nil, fmt.Errorf("get user error: %v", err)
+		return nil, fmt.Errorf("get user error: %w", err)
 	}
 
-	if includeFollowers {
+	if opts.IncludeFollowers {
 		user.Following = append(user.Following, user.Followers...)
 	}
 
+	if opts.Algorithm == FeedLatest {
+		return d.latestDocs(ctx, user.Following, n)
+	}
+
 	if n > len(user.Following) {
 		n = len(user.Following)
 	}
@@ -181,7 +275,7 @@ func (d *DBClient) GetFollowingDocs(ctx context.Context, id string, n int) ([]*D
 			dst := user.Following[dstIndex]
 			dstDocs, err := d.GetUserDocs(ctx, dst, 1)
 			if err != nil {
-				return fmt.Errorf("user docs error: %v", err)
+				return fmt.Errorf("user docs error: %w", err)
 			}
 			feedDocs = append(feedDocs, dstDocs...)
 		}
@@ -191,18 +285,56 @@ func (d *DBClient) GetFollowingDocs(ctx context.Context, id string, n int) ([]*D
 	return feedDocs, err
 }
 
+// The newest doc of up to n of users, picked at random, newest first.
+func (d *DBClient) latestDocs(ctx context.Context, users []string, n int) ([]*Document, error) {
+	d.mu.Lock()
+	order := d.rnd.Perm(len(users))
+	d.mu.Unlock()
+	if n < len(order) {
+		order = order[:n]
+	}
+
+	docKeys := make([]*datastore.Key, 0, len(order))
+	for _, i := range order {
+		u, err := d.getUser(ctx, users[i])
+		if err != nil {
+			return nil, fmt.Errorf("user docs error: %w", err)
+		}
+		if len(u.Documents) > 0 {
+			docKeys = append(docKeys, datastore.IDKey(docsTable, u.Documents[len(u.Documents)-1], nil))
+		}
+	}
+
+	docs := make([]*Document, len(docKeys))
+	err := d.pool.RunSync(ctx, func() error {
+		defer util.StartTiming(ctx, util.TimingDB)()
+		return countOp(opGetMulti, docsTable, d.client.GetMulti(ctx, docKeys, docs))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortNewestFirst(docs)
+	return docs, nil
+}
+
 func (d *DBClient) getUser(ctx context.Context, id string) (*User, error) {
 	var user User
 	err := d.pool.RunSync(ctx, func() error {
+		defer util.StartTiming(ctx, util.TimingDB)()
 		key := datastore.NameKey(userTable, id, nil)
-		return d.client.Get(ctx, key, &user)
+		err := countOp(opGet, userTable, d.client.Get(ctx, key, &user))
+		if err == datastore.ErrNoSuchEntity {
+			return ErrUserNotFound
+		}
+		return err
 	})
 
 	return &user, err
 }
 
 func ErrNoUser() (User, error) {
-	return User{}, fmt.Errorf("user should already exist")
+	return User{}, fmt.Errorf("user should already exist: %w", ErrUserNotFound)
 }
 
 func retryStrat(strat string) (int, func(int) time.Duration) {
@@ -0,0 +1,30 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"holosam/appengine/demo/pkg/features"
+
+	"cloud.google.com/go/datastore"
+)
+
+// The flags as JSON, in the format features.Parse reads.
+type flagsEntity struct {
+	JSON string `datastore:",noindex"`
+}
+
+// Reads flags from the Flags entity with the given name. A missing entity is
+// no flags, so every flag is its default.
+func (d *DBClient) FlagSource(name string) features.Source {
+	return features.SourceFunc(func(ctx context.Context) (features.Set, error) {
+		var entity flagsEntity
+		err := countOp(opGet, flagsTable, d.client.Get(ctx, datastore.NameKey(flagsTable, name, nil), &entity))
+		if err == datastore.ErrNoSuchEntity {
+			return features.Set{}, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("get flags entity %s error: %w", name, err)
+		}
+		return features.Parse([]byte(entity.JSON))
+	})
+}
@@ -0,0 +1,285 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Store that keeps everything in memory, for running without Datastore. With
+// a path, every change is also written to that file as JSON, so the data
+// survives a restart.
+type MemoryStore struct {
+	path string
+
+	mu     sync.Mutex
+	users  map[string]*User
+	docs   map[int64]*Document
+	lastID int64
+	rnd    *rand.Rand
+}
+
+// The file format.
+type memorySnapshot struct {
+	LastID    int64       `json:"lastId"`
+	Users     []*User     `json:"users"`
+	Documents []*Document `json:"documents"`
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users: make(map[string]*User),
+		docs:  make(map[int64]*Document),
+		rnd:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Loads path if it exists, and saves to it after every change.
+func OpenFileStore(path string) (*MemoryStore, error) {
+	m := NewMemoryStore()
+	m.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read store file error: %w", err)
+	}
+
+	var snap memorySnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("parse store file %s error: %w", path, err)
+	}
+	m.lastID = snap.LastID
+	for _, u := range snap.Users {
+		m.users[u.ID] = u
+	}
+	for _, d := range snap.Documents {
+		m.docs[d.ID] = d
+		if d.ID > m.lastID {
+			m.lastID = d.ID
+		}
+	}
+	return m, nil
+}
+
+func (m *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *MemoryStore) Warmup(ctx context.Context) error {
+	return nil
+}
+
+func (m *MemoryStore) Close(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.save()
+}
+
+func (m *MemoryStore) ModifyUser(ctx context.Context, id string, modify func(u *User), create func() (User, error)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var user User
+	if existing, ok := m.users[id]; ok {
+		user = copyUser(existing)
+		modify(&user)
+	} else {
+		var err error
+		if user, err = create(); err != nil {
+			return err
+		}
+	}
+
+	m.users[id] = &user
+	return m.save()
+}
+
+func (m *MemoryStore) WriteDocument(ctx context.Context, pr *PublishRequest) (*Document, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	author, ok := m.users[pr.User]
+	if !ok {
+		_, err := ErrNoUser()
+		return nil, err
+	}
+
+	m.lastID++
+	doc := &Document{
+		ID:          m.lastID,
+		Author:      pr.User,
+		PublishTime: time.Now(),
+		Text:        pr.Text,
+	}
+	m.docs[doc.ID] = doc
+
+	user := copyUser(author)
+	user.AddDocument(doc.ID)
+	m.users[user.ID] = &user
+
+	docCopy := *doc
+	return &docCopy, m.save()
+}
+
+func (m *MemoryStore) GetUser(ctx context.Context, id string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	user := copyUser(u)
+	return &user, nil
+}
+
+func (m *MemoryStore) GetUserDocs(ctx context.Context, id string, n int) ([]*Document, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.userDocs(id, n)
+}
+
+func (m *MemoryStore) GetFollowingDocs(ctx context.Context, id string, n int, opts FeedOptions) ([]*Document, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return nil, fmt.Errorf("get user error: %w", ErrUserNotFound)
+	}
+
+	following := u.Following
+	if opts.IncludeFollowers {
+		following = append(append([]string{}, u.Following...), u.Followers...)
+	}
+
+	if opts.Algorithm == FeedLatest {
+		return m.latestDocs(following, n)
+	}
+
+	if n > len(following) {
+		n = len(following)
+	}
+	feedDocs := make([]*Document, 0)
+	for i := 0; i < n; i++ {
+		dstDocs, err := m.userDocs(following[m.rnd.Intn(len(following))], 1)
+		if err != nil {
+			return nil, fmt.Errorf("user docs error: %w", err)
+		}
+		feedDocs = append(feedDocs, dstDocs...)
+	}
+	return feedDocs, nil
+}
+
+// Like DBClient.latestDocs. Must be called with m.mu held.
+func (m *MemoryStore) latestDocs(users []string, n int) ([]*Document, error) {
+	order := m.rnd.Perm(len(users))
+	if n < len(order) {
+		order = order[:n]
+	}
+
+	docs := make([]*Document, 0, len(order))
+	for _, i := range order {
+		u, ok := m.users[users[i]]
+		if !ok {
+			return nil, fmt.Errorf("user docs error: %w", ErrUserNotFound)
+		}
+		if len(u.Documents) == 0 {
+			continue
+		}
+		if doc, ok := m.docs[u.Documents[len(u.Documents)-1]]; ok {
+			docCopy := *doc
+			docs = append(docs, &docCopy)
+		}
+	}
+
+	sortNewestFirst(docs)
+	return docs, nil
+}
+
+// Picks with replacement, like DBClient does. Must be called with m.mu held.
+func (m *MemoryStore) userDocs(id string, n int) ([]*Document, error) {
+	u, ok := m.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+
+	if n > len(u.Documents) {
+		n = len(u.Documents)
+	}
+	docs := make([]*Document, 0, n)
+	for i := 0; i < n; i++ {
+		doc, ok := m.docs[u.Documents[m.rnd.Intn(len(u.Documents))]]
+		if !ok {
+			continue
+		}
+		docCopy := *doc
+		docs = append(docs, &docCopy)
+	}
+	return docs, nil
+}
+
+// Writes to a temp file and renames it, so a crash never leaves half a file.
+// Must be called with m.mu held.
+func (m *MemoryStore) save() error {
+	if m.path == "" {
+		return nil
+	}
+
+	snap := memorySnapshot{
+		LastID:    m.lastID,
+		Users:     make([]*User, 0, len(m.users)),
+		Documents: make([]*Document, 0, len(m.docs)),
+	}
+	for _, u := range m.users {
+		snap.Users = append(snap.Users, u)
+	}
+	for _, d := range m.docs {
+		snap.Documents = append(snap.Documents, d)
+	}
+	// Keeps the file stable, so it diffs well.
+	sort.Slice(snap.Users, func(i, j int) bool { return snap.Users[i].ID < snap.Users[j].ID })
+	sort.Slice(snap.Documents, func(i, j int) bool { return snap.Documents[i].ID < snap.Documents[j].ID })
+
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode store error: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), filepath.Base(m.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("save store error: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("save store error: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("save store error: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), m.path); err != nil {
+		return fmt.Errorf("save store error: %w", err)
+	}
+	return nil
+}
+
+// So callers can't change what's stored without going through ModifyUser.
+func copyUser(u *User) User {
+	user := *u
+	user.Followers = append([]string{}, u.Followers...)
+	user.Following = append([]string{}, u.Following...)
+	user.Documents = append([]int64{}, u.Documents...)
+	return user
+}
@@ -0,0 +1,64 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "store.json")
+	m, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.WriteDocument(ctx, &PublishRequest{User: "alice", Text: "hi"}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Got %v publishing for a missing user, want %v", err, ErrUserNotFound)
+	}
+
+	for _, id := range []string{"alice", "bob"} {
+		id := id
+		if err := m.ModifyUser(ctx, id, func(u *User) {}, func() (User, error) { return NewUser(id), nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	doc, err := m.WriteDocument(ctx, &PublishRequest{User: "bob", Text: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ModifyUser(ctx, "alice", func(u *User) { u.AddFollowing("bob") }, ErrNoUser); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopening reads back what was saved.
+	m, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs, err := m.GetFollowingDocs(ctx, "alice", 5, FeedOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0].ID != doc.ID {
+		t.Errorf("Got %v, want [%v]", docs, doc)
+	}
+
+	next, err := m.WriteDocument(ctx, &PublishRequest{User: "bob", Text: "again"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.ID <= doc.ID {
+		t.Errorf("Got ID %v after reopening, want more than %v", next.ID, doc.ID)
+	}
+
+	docs, err = m.GetFollowingDocs(ctx, "alice", 5, FeedOptions{Algorithm: FeedLatest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0].ID != next.ID {
+		t.Errorf("Got %v for the latest docs, want [%v]", docs, next)
+	}
+}
@@ -0,0 +1,37 @@
+package database
+
+import (
+	"holosam/appengine/demo/pkg/util"
+
+	"cloud.google.com/go/datastore"
+)
+
+const (
+	opGet         = "Get"
+	opGetMulti    = "GetMulti"
+	opPut         = "Put"
+	opAllocateIDs = "AllocateIDs"
+	opTransaction = "Transaction"
+)
+
+var (
+	dbOps = util.DefaultRegistry.NewCounterVec("datastore_operations_total",
+		"Datastore operations, by operation, entity kind and result.", "op", "kind", "result")
+	txnRetries = util.DefaultRegistry.NewCounterVec("datastore_transaction_retries_total",
+		"Transactions retried by ModifyUser, by entity kind.", "kind")
+	txnConflicts = util.DefaultRegistry.NewCounterVec("datastore_transaction_conflicts_total",
+		"Transactions that failed with ErrConcurrentTransaction, by entity kind.", "kind")
+)
+
+// Passes err through, so it can wrap the return value of a Datastore call.
+func countOp(op, kind string, err error) error {
+	result := "ok"
+	if err == datastore.ErrNoSuchEntity {
+		result = "not_found"
+	} else if err != nil {
+		result = "error"
+	}
+
+	dbOps.Inc(op, kind, result)
+	return err
+}
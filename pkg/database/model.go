@@ -1,28 +1,36 @@
 package database
 
-import "time"
+import (
+	"sort"
+	"time"
+)
 
 const (
-	userTable = "Users"
-	docsTable = "Documents"
+	userTable  = "Users"
+	docsTable  = "Documents"
+	flagsTable = "Flags"
+
+	// Never created, only read to check that Datastore is reachable. The dash
+	// keeps it out of the usernames that the feed accepts.
+	pingUser = "planechat-ping"
 )
 
 type User struct {
-	ID        string
-	Followers []string // datastore doesn't support maps
-	Following []string
+	ID        string   `json:"id"`
+	Followers []string `json:"followers"` // datastore doesn't support maps
+	Following []string `json:"following"`
 
-	Documents []int64
+	Documents []int64 `json:"documents"`
 
-	Logins    int64
-	LastLogin time.Time
+	Logins    int64     `json:"logins"`
+	LastLogin time.Time `json:"lastLogin"`
 }
 
 type Document struct {
-	ID          int64
-	Author      string
-	PublishTime time.Time
-	Text        string `datastore:",noindex"`
+	ID          int64     `json:"id"`
+	Author      string    `json:"author"`
+	PublishTime time.Time `json:"publishTime"`
+	Text        string    `json:"text" datastore:",noindex"`
 }
 
 type FollowRequest struct {
@@ -35,6 +43,34 @@ type PublishRequest struct {
 	Text string `json:"text"`
 }
 
+type PublishResponse struct {
+	// The new document's ID.
+	ID   int64  `json:"id"`
+	User string `json:"user"`
+}
+
+type FollowResponse struct {
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+	// How many users src follows and dst is followed by, after the change.
+	Following int `json:"following"`
+	Followers int `json:"followers"`
+}
+
+const (
+	// One random doc from each of n random followed users.
+	FeedRandom = "random"
+	// The newest doc from each of up to n followed users, newest first.
+	FeedLatest = "latest"
+)
+
+// How GetFollowingDocs picks docs.
+type FeedOptions struct {
+	Algorithm string
+	// Also take docs from the user's followers.
+	IncludeFollowers bool
+}
+
 func NewUser(id string) User {
 	return User{
 		ID:        id,
@@ -81,3 +117,9 @@ func sliceContainsInt(x int64, slice []int64) bool {
 	}
 	return false
 }
+
+func sortNewestFirst(docs []*Document) {
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].PublishTime.After(docs[j].PublishTime)
+	})
+}
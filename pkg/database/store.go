@@ -0,0 +1,23 @@
+package database
+
+import "context"
+
+// What the services need from storage. DBClient is the real one, MemoryStore
+// is for running offline.
+type Store interface {
+	// Calls modify on the user, or saves create's user if there isn't one.
+	ModifyUser(ctx context.Context, id string, modify func(u *User), create func() (User, error)) error
+	// Saves the doc with a new ID and adds it to the author, who must exist.
+	WriteDocument(ctx context.Context, pr *PublishRequest) (*Document, error)
+	GetUser(ctx context.Context, id string) (*User, error)
+	// Up to n of the user's docs, picked at random.
+	GetUserDocs(ctx context.Context, id string, n int) ([]*Document, error)
+	// Up to n docs from the users that id follows, picked as opts says.
+	GetFollowingDocs(ctx context.Context, id string, n int, opts FeedOptions) ([]*Document, error)
+
+	Ping(ctx context.Context) error
+	Warmup(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
+var _ Store = (*DBClient)(nil)
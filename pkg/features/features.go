@@ -0,0 +1,273 @@
+// Feature flags that can change without a redeploy. Every flag has variants,
+// with "on" and "off" for boolean flags. Which variant a user gets comes from,
+// in order: an override for that user, the percentage rollout by a stable
+// hash of the user ID, the default from the source, and the default in code.
+package features
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"holosam/appengine/demo/pkg/logging"
+)
+
+const (
+	On  = "on"
+	Off = "off"
+
+	SourceNone      = "none"
+	SourceFile      = "file"
+	SourceDatastore = "datastore"
+)
+
+var logger = logging.New("features")
+
+// A flag as the code knows it. Sources can only pick between its variants.
+type Definition struct {
+	Name     string   `json:"name"`
+	Variants []string `json:"variants"`
+	Default  string   `json:"default"`
+}
+
+func Bool(name string, def bool) Definition {
+	d := Definition{Name: name, Variants: []string{On, Off}, Default: Off}
+	if def {
+		d.Default = On
+	}
+	return d
+}
+
+func Variant(name, def string, variants ...string) Definition {
+	return Definition{Name: name, Variants: variants, Default: def}
+}
+
+// A flag as a source sets it.
+type Flag struct {
+	Default string `json:"default,omitempty"`
+	// Applied in order, so the first rule covers users in buckets
+	// [0, percent), the next one the percent after that, and so on.
+	Rollout []Rule `json:"rollout,omitempty"`
+	// By user ID, ahead of the rollout.
+	Overrides map[string]string `json:"overrides,omitempty"`
+}
+
+type Rule struct {
+	Variant string  `json:"variant"`
+	Percent float64 `json:"percent"`
+}
+
+// Flags by name.
+type Set map[string]Flag
+
+// Where flags come from. Load is called again on every reload.
+type Source interface {
+	Load(ctx context.Context) (Set, error)
+}
+
+type SourceFunc func(ctx context.Context) (Set, error)
+
+func (f SourceFunc) Load(ctx context.Context) (Set, error) {
+	return f(ctx)
+}
+
+type Config struct {
+	Source  string        `env:"FLAGS_SOURCE" default:"none" oneof:"none|file|datastore"`
+	File    string        `env:"FLAGS_FILE"`
+	Entity  string        `env:"FLAGS_ENTITY" default:"default"`
+	Refresh time.Duration `env:"FLAGS_REFRESH" default:"30s" min:"1s"`
+}
+
+func (c *Config) Validate() error {
+	if c.Source == SourceFile && c.File == "" {
+		return fmt.Errorf("FLAGS_FILE is required when FLAGS_SOURCE is file")
+	}
+	return nil
+}
+
+type Flags struct {
+	source Source
+	defs   map[string]Definition
+
+	mu      sync.RWMutex
+	current Set
+	loaded  time.Time
+}
+
+// A nil source means every flag is always its default.
+func New(source Source, defs ...Definition) *Flags {
+	f := &Flags{
+		source:  source,
+		defs:    make(map[string]Definition, len(defs)),
+		current: make(Set),
+	}
+	for _, d := range defs {
+		f.defs[d.Name] = d
+	}
+	return f
+}
+
+// Which variant user gets. Flags that weren't defined are always "".
+func (f *Flags) Variant(ctx context.Context, name, user string) string {
+	def, ok := f.defs[name]
+	if !ok {
+		logger.Warningf(ctx, "Unknown flag %s", name)
+		return ""
+	}
+
+	f.mu.RLock()
+	flag, ok := f.current[name]
+	f.mu.RUnlock()
+	if !ok {
+		return def.Default
+	}
+
+	if v, ok := flag.Overrides[user]; ok {
+		return v
+	}
+	b := bucket(name, user)
+	total := 0.0
+	for _, rule := range flag.Rollout {
+		total += rule.Percent
+		if b < total {
+			return rule.Variant
+		}
+	}
+	if flag.Default != "" {
+		return flag.Default
+	}
+	return def.Default
+}
+
+func (f *Flags) Enabled(ctx context.Context, name, user string) bool {
+	return f.Variant(ctx, name, user) == On
+}
+
+// Swaps in the source's flags if they're all valid, otherwise keeps the ones
+// from before.
+func (f *Flags) Reload(ctx context.Context) error {
+	if f.source == nil {
+		return nil
+	}
+
+	set, err := f.source.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("load flags error: %w", err)
+	}
+	if err := f.validate(set); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.current = set
+	f.loaded = time.Now()
+	return nil
+}
+
+// Reloads every interval until ctx ends.
+func (f *Flags) Watch(ctx context.Context, interval time.Duration) {
+	if f.source == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := f.Reload(ctx); err != nil {
+				logger.Warningf(ctx, "Flag reload failed, keeping the current flags: %v", err)
+			}
+		}
+	}
+}
+
+func (f *Flags) validate(set Set) error {
+	problems := make([]string, 0)
+	for name, flag := range set {
+		def, ok := f.defs[name]
+		if !ok {
+			// Lets a source set a flag before the code that reads it ships.
+			continue
+		}
+
+		check := func(where, v string) {
+			for _, variant := range def.Variants {
+				if v == variant {
+					return
+				}
+			}
+			problems = append(problems, fmt.Sprintf("%s: %s %q isn't one of %s", name, where, v, strings.Join(def.Variants, ", ")))
+		}
+		if flag.Default != "" {
+			check("default", flag.Default)
+		}
+		total := 0.0
+		for _, rule := range flag.Rollout {
+			check("rollout variant", rule.Variant)
+			if rule.Percent < 0 {
+				problems = append(problems, fmt.Sprintf("%s: rollout percent %v is negative", name, rule.Percent))
+			}
+			total += rule.Percent
+		}
+		if total > 100 {
+			problems = append(problems, fmt.Sprintf("%s: rollout adds up to %v%%", name, total))
+		}
+		for user, v := range flag.Overrides {
+			check("override for "+user, v)
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid flags: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Where user falls in [0, 100) for a flag. Hashing the name in too means a
+// user at the start of one rollout isn't at the start of every rollout.
+func bucket(name, user string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(user))
+	return float64(h.Sum32()%10000) / 100
+}
+
+// Parses the JSON that file and Datastore sources hold: flags by name.
+func Parse(data []byte) (Set, error) {
+	var set Set
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parse flags error: %w", err)
+	}
+	return set, nil
+}
+
+type flagsStatus struct {
+	Loaded      time.Time             `json:"loaded,omitempty"`
+	Definitions map[string]Definition `json:"definitions"`
+	Flags       Set                   `json:"flags"`
+}
+
+// Serves the definitions and what the source last set, for /debug/flags.
+func (f *Flags) Handler(w http.ResponseWriter, r *http.Request) {
+	f.mu.RLock()
+	status := flagsStatus{Loaded: f.loaded, Definitions: f.defs, Flags: f.current}
+	f.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		logger.Errorf(r.Context(), "Flags encode error: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package features
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVariant(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "flags.json")
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := New(FileSource(path), Bool("beta", false), Variant("algo", "a", "a", "b"))
+	if got := f.Variant(ctx, "algo", "alice"); got != "a" {
+		t.Errorf("Got %v before loading, want a", got)
+	}
+
+	write(`{"beta": {"rollout": [{"variant": "on", "percent": 25}], "overrides": {"alice": "on", "bob": "off"}}}`)
+	if err := f.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !f.Enabled(ctx, "beta", "alice") || f.Enabled(ctx, "beta", "bob") {
+		t.Errorf("Got %v and %v for the overrides, want true and false", f.Enabled(ctx, "beta", "alice"), f.Enabled(ctx, "beta", "bob"))
+	}
+
+	on := 0
+	for i := 0; i < 10000; i++ {
+		user := fmt.Sprintf("user%d", i)
+		enabled := f.Enabled(ctx, "beta", user)
+		if enabled != f.Enabled(ctx, "beta", user) {
+			t.Fatalf("Got a different variant for %s the second time", user)
+		}
+		if enabled {
+			on++
+		}
+	}
+	if math.Abs(float64(on)/100-25) > 2 {
+		t.Errorf("Got %v%% on, want about 25%%", float64(on)/100)
+	}
+
+	// A bad file is rejected as a whole, and the last good flags stay.
+	write(`{"beta": {"default": "on"}, "algo": {"default": "c"}}`)
+	if err := f.Reload(ctx); err == nil {
+		t.Errorf("Got no error for an unknown variant")
+	}
+	if f.Enabled(ctx, "beta", "bob") {
+		t.Errorf("Got the bad flags, want the last good ones")
+	}
+}
@@ -0,0 +1,19 @@
+package features
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// Reads the flags from a JSON file on every load, so editing the file is
+// enough to change them.
+func FileSource(path string) Source {
+	return SourceFunc(func(ctx context.Context) (Set, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read flags file error: %w", err)
+		}
+		return Parse(data)
+	})
+}
@@ -0,0 +1,239 @@
+package feedsvc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"holosam/appengine/demo/pkg/database"
+	"holosam/appengine/demo/pkg/util"
+)
+
+const apiPrefix = "/api/v1"
+
+// Every response body from the API is wrapped in this envelope, so clients
+// can always check for "error" before reading "data". Errors from
+// util.WriteJSONError have the same shape, with codes from the util kinds.
+type apiResponse struct {
+	Data  interface{} `json:"data,omitempty"`
+	Error *apiError   `json:"error,omitempty"`
+}
+
+type apiError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type apiRoute struct {
+	method string
+	// Relative to apiPrefix, with path parameters in OpenAPI form: /users/{user}
+	path    string
+	summary string
+	// Zero values of the request and response types, used for the OpenAPI doc.
+	request  interface{}
+	response interface{}
+	status   int
+
+	handle http.HandlerFunc
+}
+
+func (h *Handler) apiRoutes() []apiRoute {
+	return []apiRoute{
+		{
+			method:   http.MethodGet,
+			path:     "/users/{user}",
+			summary:  "Get a user's profile.",
+			response: database.User{},
+			status:   http.StatusOK,
+			handle:   h.apiUserHandler,
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/users/{user}/feed",
+			summary:  "Build the feed page for a user.",
+			response: FeedTmpl{},
+			status:   http.StatusOK,
+			handle:   h.apiFeedHandler,
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/users/{user}/docs",
+			summary:  "Get a sample of the user's own documents.",
+			response: []database.Document{},
+			status:   http.StatusOK,
+			handle:   h.apiDocsHandler,
+		},
+		{
+			method:   http.MethodPost,
+			path:     "/publish",
+			summary:  "Publish a new document for a user.",
+			request:  database.PublishRequest{},
+			response: database.PublishResponse{},
+			status:   http.StatusCreated,
+			handle:   h.apiPublishHandler,
+		},
+		{
+			method:   http.MethodPost,
+			path:     "/follow",
+			summary:  "Make the src user follow the dst user.",
+			request:  database.FollowRequest{},
+			response: database.FollowResponse{},
+			status:   http.StatusOK,
+			handle:   h.apiFollowHandler,
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/openapi.json",
+			summary: "This document.",
+			status:  http.StatusOK,
+			handle:  h.apiOpenAPIHandler,
+		},
+	}
+}
+
+func (h *Handler) registerAPI(api *util.Router) {
+	for _, route := range h.apiRoutes() {
+		api.HandleFunc(route.method, route.path, route.handle)
+	}
+
+	// Unknown paths and methods get a JSON error instead of the HTML pages.
+	api.NotFound(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, "not_found", fmt.Sprintf("no such endpoint %s", r.URL.Path))
+	}))
+	api.MethodNotAllowed(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", fmt.Sprintf("method %s not allowed on %s", r.Method, r.URL.Path))
+	}))
+}
+
+func (h *Handler) apiUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := apiUser(r)
+	if err != nil {
+		util.WriteJSONError(w, r, err)
+		return
+	}
+	util.SetRequestUser(r.Context(), user)
+
+	profile, err := h.db.GetUser(r.Context(), user)
+	if err != nil {
+		util.WriteJSONError(w, r, err)
+		return
+	}
+
+	writeAPIData(w, http.StatusOK, profile)
+}
+
+func (h *Handler) apiFeedHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := apiUser(r)
+	if err != nil {
+		util.WriteJSONError(w, r, err)
+		return
+	}
+	util.SetRequestUser(r.Context(), user)
+
+	feed, err := h.buildFeed(r.Context(), user)
+	if err != nil {
+		util.WriteJSONError(w, r, err)
+		return
+	}
+
+	writeAPIData(w, http.StatusOK, feed)
+}
+
+func (h *Handler) apiDocsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := apiUser(r)
+	if err != nil {
+		util.WriteJSONError(w, r, err)
+		return
+	}
+	util.SetRequestUser(r.Context(), user)
+
+	docs, err := h.db.GetUserDocs(r.Context(), user, h.cfg.SelfDocs)
+	if err != nil {
+		util.WriteJSONError(w, r, err)
+		return
+	}
+
+	writeAPIData(w, http.StatusOK, docs)
+}
+
+func (h *Handler) apiPublishHandler(w http.ResponseWriter, r *http.Request) {
+	pr, err := util.DecodeJSON[database.PublishRequest](w, r)
+	if err != nil {
+		util.WriteJSONError(w, r, err)
+		return
+	}
+	if pr.User == "" || pr.Text == "" {
+		util.WriteJSONError(w, r, util.NewError(util.KindInvalid, "user and text are required", nil))
+		return
+	}
+	util.SetRequestUser(r.Context(), pr.User)
+
+	published, err := h.users.Publish(r.Context(), &pr)
+	if err != nil {
+		util.WriteJSONError(w, r, err)
+		return
+	}
+
+	writeAPIData(w, http.StatusCreated, published)
+}
+
+func (h *Handler) apiFollowHandler(w http.ResponseWriter, r *http.Request) {
+	fr, err := util.DecodeJSON[database.FollowRequest](w, r)
+	if err != nil {
+		util.WriteJSONError(w, r, err)
+		return
+	}
+	if fr.Src == "" || fr.Dst == "" {
+		util.WriteJSONError(w, r, util.NewError(util.KindInvalid, "src and dst are required", nil))
+		return
+	}
+	util.SetRequestUser(r.Context(), fr.Src)
+
+	followed, err := h.users.Follow(r.Context(), &fr)
+	if err != nil {
+		util.WriteJSONError(w, r, err)
+		return
+	}
+
+	writeAPIData(w, http.StatusOK, followed)
+}
+
+func (h *Handler) apiOpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(buildOpenAPI(h.apiRoutes())); err != nil {
+		logger.Errorf(r.Context(), "OpenAPI encode error: %v", err)
+	}
+}
+
+// Checks the user the same way the pages do, so both reject the same names.
+func apiUser(r *http.Request) (string, error) {
+	user := util.PathParam(r, "user")
+	if !userRegex.MatchString(user) {
+		return "", util.NewError(util.KindNotFound, "no such user", nil)
+	}
+	return user, nil
+}
+
+func writeAPIData(w http.ResponseWriter, status int, data interface{}) {
+	writeAPIResponse(w, status, apiResponse{Data: data})
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, msg string) {
+	writeAPIResponse(w, status, apiResponse{Error: &apiError{
+		Status:  status,
+		Code:    code,
+		Message: msg,
+	}})
+}
+
+func writeAPIResponse(w http.ResponseWriter, status int, resp apiResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Errorf(context.Background(), "API encode error: %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package feedsvc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"holosam/appengine/demo/pkg/database"
+	"holosam/appengine/demo/pkg/features"
+	"holosam/appengine/demo/pkg/userclient"
+	"holosam/appengine/demo/pkg/usersvc"
+	"holosam/appengine/demo/pkg/util"
+)
+
+type testEnvelope struct {
+	Data  json.RawMessage `json:"data"`
+	Error *apiError       `json:"error"`
+}
+
+// A feed handler on the in-memory store, with alice and bob already there,
+// and bad-name, which the store takes but the handlers don't.
+func newTestRouter(t *testing.T) *util.Router {
+	t.Helper()
+	ctx := context.Background()
+	store := database.NewMemoryStore()
+	for _, id := range []string{"alice", "bob", "bad-name"} {
+		id := id
+		if err := store.ModifyUser(ctx, id, func(u *database.User) {}, func() (database.User, error) { return database.NewUser(id), nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := Config{SelfDocs: 3, FeedDocs: 5, FeedAlgorithm: database.FeedRandom, TemplateDir: "../../templates"}
+	flags := features.New(nil, FlagDefinitions(cfg)...)
+	users := userclient.NewLocal(usersvc.New(store, nil))
+	h := New(cfg, store, users, flags, util.NewFlashStore([]byte("test key")))
+
+	router := util.NewRouter()
+	h.Register(router)
+	return router
+}
+
+func serveAPI(router http.Handler, method, path, body string) (int, testEnvelope, error) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var env testEnvelope
+	err := json.Unmarshal(w.Body.Bytes(), &env)
+	return w.Code, env, err
+}
+
+func TestAPI(t *testing.T) {
+	router := newTestRouter(t)
+
+	tests := []struct {
+		method, path, body string
+		status             int
+		// Empty for a success, which has data instead.
+		code string
+	}{
+		{http.MethodGet, "/api/v1/users/alice", "", http.StatusOK, ""},
+		{http.MethodGet, "/api/v1/users/nobody", "", http.StatusNotFound, "not_found"},
+		{http.MethodGet, "/api/v1/users/bad-name", "", http.StatusNotFound, "not_found"},
+		{http.MethodGet, "/api/v1/users/bad-name/feed", "", http.StatusNotFound, "not_found"},
+		{http.MethodGet, "/api/v1/users/bad-name/docs", "", http.StatusNotFound, "not_found"},
+		{http.MethodGet, "/api/v1/users/alice/feed", "", http.StatusOK, ""},
+		{http.MethodGet, "/api/v1/users/alice/docs", "", http.StatusOK, ""},
+		{http.MethodGet, "/api/v1/nothing", "", http.StatusNotFound, "not_found"},
+		{http.MethodPost, "/api/v1/publish", `{"user":"alice","text":"hi"}`, http.StatusCreated, ""},
+		{http.MethodPost, "/api/v1/publish", `{"user":"alice"}`, http.StatusBadRequest, "invalid_input"},
+		{http.MethodPost, "/api/v1/publish", `{"user":`, http.StatusBadRequest, "invalid_input"},
+		{http.MethodPost, "/api/v1/publish", `{"user":"nobody","text":"hi"}`, http.StatusNotFound, "not_found"},
+		{http.MethodPost, "/api/v1/follow", `{"src":"alice","dst":"bob"}`, http.StatusOK, ""},
+		// Unknown fields are ignored, like everywhere else util.DecodeJSON reads.
+		{http.MethodPost, "/api/v1/follow", `{"src":"alice","dst":"bob","extra":1}`, http.StatusOK, ""},
+		{http.MethodPut, "/api/v1/publish", "", http.StatusMethodNotAllowed, "method_not_allowed"},
+	}
+
+	for _, test := range tests {
+		status, env, err := serveAPI(router, test.method, test.path, test.body)
+		if err != nil {
+			t.Errorf("%s %s: Got %v decoding the body, want the envelope", test.method, test.path, err)
+			continue
+		}
+		if got, want := status, test.status; got != want {
+			t.Errorf("%s %s: Got %v, want %v", test.method, test.path, got, want)
+		}
+
+		if test.code == "" {
+			if env.Error != nil || len(env.Data) == 0 {
+				t.Errorf("%s %s: Got error %+v and data %s, want only data", test.method, test.path, env.Error, env.Data)
+			}
+			continue
+		}
+		if env.Error == nil || len(env.Data) != 0 {
+			t.Errorf("%s %s: Got error %+v and data %s, want only an error", test.method, test.path, env.Error, env.Data)
+			continue
+		}
+		if got, want := env.Error.Code, test.code; got != want {
+			t.Errorf("%s %s: Got %v, want %v", test.method, test.path, got, want)
+		}
+		if got, want := env.Error.Status, test.status; got != want {
+			t.Errorf("%s %s: Got %v, want %v", test.method, test.path, got, want)
+		}
+	}
+}
+
+func TestAPIPublish(t *testing.T) {
+	router := newTestRouter(t)
+
+	_, env, err := serveAPI(router, http.MethodPost, "/api/v1/publish", `{"user":"bob","text":"hello"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var published database.PublishResponse
+	if err := json.Unmarshal(env.Data, &published); err != nil {
+		t.Fatal(err)
+	}
+
+	_, env, err = serveAPI(router, http.MethodGet, "/api/v1/users/bob/docs", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var docs []database.Document
+	if err := json.Unmarshal(env.Data, &docs); err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0].Text != "hello" || docs[0].ID != published.ID {
+		t.Errorf("Got %+v, want the published doc #%d", docs, published.ID)
+	}
+}
+
+func TestOpenAPIListsRoutes(t *testing.T) {
+	router := newTestRouter(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var doc struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	for _, route := range router.Routes() {
+		if !strings.HasPrefix(route.Pattern, apiPrefix) || route.Method == http.MethodHead {
+			continue
+		}
+		count++
+		if _, ok := doc.Paths[route.Pattern][strings.ToLower(route.Method)]; !ok {
+			t.Errorf("Got no %s %s in the OpenAPI doc", route.Method, route.Pattern)
+		}
+	}
+	if got, want := count, len((&Handler{}).apiRoutes()); got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+}
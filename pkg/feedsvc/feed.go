@@ -0,0 +1,342 @@
+package feedsvc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"holosam/appengine/demo/pkg/database"
+	"holosam/appengine/demo/pkg/features"
+	"holosam/appengine/demo/pkg/logging"
+	"holosam/appengine/demo/pkg/secrets"
+	"holosam/appengine/demo/pkg/userclient"
+	"holosam/appengine/demo/pkg/util"
+)
+
+var (
+	userRegex = regexp.MustCompile(`^\w+$`)
+	// The pages name the user with the leading word characters of the path,
+	// like the old `^/user/(\w+)` match, so /user/alice-bob and
+	// /user/alice/anything are both alice's page.
+	userPathRegex = regexp.MustCompile(`^\w+`)
+
+	logger = logging.New("feed")
+)
+
+const (
+	FlagIncludeFollowers = "include_followers"
+	FlagFeedAlgorithm    = "feed_algorithm"
+)
+
+type Config struct {
+	SelfDocs int `env:"SELF_DOCS" default:"3" min:"0" max:"50"`
+	FeedDocs int `env:"FEED_DOCS" default:"5" min:"0" max:"50"`
+	// The defaults for the flags, for users the flag source doesn't cover.
+	IncludeFollowers bool   `env:"INCLUDE_FOLLOWERS" default:"false"`
+	FeedAlgorithm    string `env:"FEED_ALGORITHM" default:"random" oneof:"random|latest"`
+
+	Headline  string `env:"HEADLINE" default:"Welcome"`
+	TextColor string `env:"TEXT_COLOR" default:"black"`
+	// Relative to the working directory.
+	TemplateDir string `env:"TEMPLATE_DIR" default:"templates"`
+}
+
+// Everything service-feed reads from the environment.
+type ServiceConfig struct {
+	Project string `env:"GOOGLE_CLOUD_PROJECT" required:"true"`
+	Version string `env:"GAE_VERSION" default:"[not found]"`
+
+	Server  util.ServerConfig
+	DB      database.Config
+	Users   userclient.Config
+	Feed    Config
+	Flags   features.Config
+	Secrets secrets.Config
+}
+
+type Handler struct {
+	cfg      Config
+	db       database.Store
+	users    userclient.Client
+	flags    *features.Flags
+	flash    *util.FlashStore
+	baseTmpl *BaseTmpl
+
+	templatesOnce sync.Once
+	templates     *template.Template
+	templatesErr  error
+}
+
+// Set once at startup and shared, so it's never written per request.
+type BaseTmpl struct {
+	Headline  string
+	TextColor string
+}
+
+type LandTmpl struct {
+	*BaseTmpl
+	Flashes []util.Flash
+}
+
+type FeedTmpl struct {
+	Headline string       `json:"headline"`
+	User     string       `json:"user"`
+	Feed     []DocTmpl    `json:"feed"`
+	Self     []DocTmpl    `json:"self"`
+	Flashes  []util.Flash `json:"-"`
+}
+
+type DocTmpl struct {
+	Author string `json:"author"`
+	Text   string `json:"text"`
+}
+
+// The flags the handler reads, for features.New.
+func FlagDefinitions(cfg Config) []features.Definition {
+	return []features.Definition{
+		features.Bool(FlagIncludeFollowers, cfg.IncludeFollowers),
+		features.Variant(FlagFeedAlgorithm, cfg.FeedAlgorithm, database.FeedRandom, database.FeedLatest),
+	}
+}
+
+// flags needs FlagDefinitions.
+func New(cfg Config, db database.Store, users userclient.Client, flags *features.Flags, flash *util.FlashStore) *Handler {
+	return &Handler{
+		cfg:   cfg,
+		db:    db,
+		users: users,
+		flags: flags,
+		flash: flash,
+		baseTmpl: &BaseTmpl{
+			Headline:  cfg.Headline,
+			TextColor: cfg.TextColor,
+		},
+	}
+}
+
+// Adds the pages, the form handlers and the API. The pages link to each other
+// with absolute paths, so router has to serve from the root.
+func (h *Handler) Register(router *util.Router) {
+	// Any other path gets the land page, as it always has, so old links and
+	// things like /favicon.ico don't become errors.
+	router.NotFound(http.HandlerFunc(h.baseHandler))
+	router.MethodNotAllowed(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.writeError(w, r, util.NewError(util.KindMethodNotAllowed, fmt.Sprintf("%s isn't allowed here", r.Method), nil))
+	}))
+	// The pages take any method, as they did on http.ServeMux, and read their
+	// params from the query string.
+	router.HandleFunc(util.MethodAny, "/", h.baseHandler)
+	router.HandleFunc(util.MethodAny, "/publish", h.publishHandler)
+	router.HandleFunc(util.MethodAny, "/follow", h.followHandler)
+	router.HandleFunc(util.MethodAny, "/user", h.redirectHandler)
+	router.HandleFunc(util.MethodAny, "/user/{user...}", h.loginHandler)
+	h.registerAPI(router.Group(apiPrefix))
+}
+
+func (h *Handler) baseHandler(w http.ResponseWriter, r *http.Request) {
+	land := &LandTmpl{
+		BaseTmpl: h.baseTmpl,
+		Flashes:  h.flash.Pop(w, r),
+	}
+	if err := h.executeTemplate(r.Context(), w, "land.html", land); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+}
+
+// This exists because I don't know how to go directly to /user/username from
+// the land.html form entry, so this takes in the user as a param and then
+// redirects.
+func (h *Handler) redirectHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := getParam(r, "user")
+	if err != nil {
+		h.flash.Add(w, r, util.FlashWarning, "Enter a username to log in")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/user/%s", user), http.StatusFound)
+}
+
+func (h *Handler) loginHandler(w http.ResponseWriter, r *http.Request) {
+	user := userPathRegex.FindString(util.PathParam(r, "user"))
+	if user == "" {
+		h.writeError(w, r, util.NewError(util.KindNotFound, "no such user", nil))
+		return
+	}
+	util.SetRequestUser(r.Context(), user)
+
+	err := h.db.ModifyUser(r.Context(), user, func(u *database.User) {
+		u.Logins += 1
+		u.LastLogin = time.Now()
+	}, func() (database.User, error) {
+		return database.NewUser(user), nil
+	})
+	if err != nil {
+		h.writeError(w, r, util.NewError(util.KindOf(err), "failed to access user", err))
+		return
+	}
+
+	feedTmpl, err := h.buildFeed(r.Context(), user)
+	if err != nil {
+		h.writeError(w, r, util.NewError(util.KindOf(err), "failed to access docs", err))
+		return
+	}
+
+	feedTmpl.Flashes = h.flash.Pop(w, r)
+	if err := h.executeTemplate(r.Context(), w, "feed.html", feedTmpl); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+}
+
+// Should surface docs from people who they aren't following too?
+func (h *Handler) buildFeed(ctx context.Context, user string) (*FeedTmpl, error) {
+	feed := &FeedTmpl{
+		Headline: fmt.Sprintf("Welcome %s!", user),
+		User:     user,
+		Feed:     make([]DocTmpl, 0),
+		Self:     make([]DocTmpl, 0),
+	}
+
+	selfDocs, err := h.db.GetUserDocs(ctx, user, h.cfg.SelfDocs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, doc := range selfDocs {
+		feed.Self = append(feed.Self, DocTmpl{
+			Author: doc.Author,
+			Text:   doc.Text,
+		})
+	}
+
+	feedDocs, err := h.db.GetFollowingDocs(ctx, user, h.cfg.FeedDocs, database.FeedOptions{
+		Algorithm:        h.flags.Variant(ctx, FlagFeedAlgorithm, user),
+		IncludeFollowers: h.flags.Enabled(ctx, FlagIncludeFollowers, user),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, doc := range feedDocs {
+		feed.Feed = append(feed.Feed, DocTmpl{
+			Author: doc.Author,
+			Text:   doc.Text,
+		})
+	}
+
+	return feed, nil
+}
+
+func (h *Handler) publishHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := getParam(r, "user")
+	if err != nil {
+		logger.Infof(r.Context(), "Missing user param")
+		h.flash.Add(w, r, util.FlashWarning, "Log in to publish")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	util.SetRequestUser(r.Context(), user)
+
+	text, err := getParam(r, "text")
+	if err != nil {
+		logger.Infof(r.Context(), "Missing text param")
+		h.flash.Add(w, r, util.FlashWarning, "Nothing to publish")
+		http.Redirect(w, r, fmt.Sprintf("/user/%s", user), http.StatusSeeOther)
+		return
+	}
+
+	published, err := h.users.Publish(r.Context(), &database.PublishRequest{
+		User: user,
+		Text: text,
+	})
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.flash.Add(w, r, util.FlashSuccess, fmt.Sprintf("Published doc #%d", published.ID))
+	http.Redirect(w, r, fmt.Sprintf("/user/%s", user), http.StatusFound)
+}
+
+func (h *Handler) followHandler(w http.ResponseWriter, r *http.Request) {
+	src, srcErr := getParam(r, "src")
+	dst, dstErr := getParam(r, "dst")
+	if srcErr != nil || dstErr != nil {
+		logger.Infof(r.Context(), "Missing src and/or dst user param")
+		h.flash.Add(w, r, util.FlashWarning, "Pick someone to follow")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	util.SetRequestUser(r.Context(), src)
+
+	followed, err := h.users.Follow(r.Context(), &database.FollowRequest{
+		Src: src,
+		Dst: dst,
+	})
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.flash.Add(w, r, util.FlashSuccess, fmt.Sprintf("Now following %s, who has %d followers", dst, followed.Followers))
+	http.Redirect(w, r, fmt.Sprintf("/user/%s", src), http.StatusFound)
+}
+
+// Parsed on first use, which is normally the warmup request.
+func (h *Handler) LoadTemplates(ctx context.Context) error {
+	h.templatesOnce.Do(func() {
+		h.templates, h.templatesErr = template.ParseGlob(filepath.Join(h.cfg.TemplateDir, "*.html"))
+	})
+	return h.templatesErr
+}
+
+// Loads the templates first, so even the first error gets the real error
+// page. If they don't load, util falls back to its plain one.
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var page *template.Template
+	if h.LoadTemplates(r.Context()) == nil {
+		page = h.templates.Lookup("error.html")
+	}
+	util.WriteErrorPage(w, r, err, page)
+}
+
+// Renders into a buffer first, so a failed render can still become an error
+// page and the render time makes it into the Server-Timing header.
+func (h *Handler) executeTemplate(ctx context.Context, w io.Writer, name string, data interface{}) error {
+	if err := h.LoadTemplates(ctx); err != nil {
+		return err
+	}
+
+	stopTiming := util.StartTiming(ctx, util.TimingRender)
+	var buf bytes.Buffer
+	err := h.templates.ExecuteTemplate(&buf, name, data)
+	stopTiming()
+	if err != nil {
+		return err
+	}
+
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// Path parameters take priority over the query string.
+func getParam(r *http.Request, param string) (string, error) {
+	if v := util.PathParam(r, param); v != "" {
+		return v, nil
+	}
+
+	params, ok := r.URL.Query()[param]
+	if !ok || len(params) == 0 {
+		return "", fmt.Errorf("missing %s param", param)
+	}
+	return params[0], nil
+}
@@ -0,0 +1,58 @@
+package feedsvc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPages(t *testing.T) {
+	router := newTestRouter(t)
+	// Like the health checks the services serve next to the pages.
+	router.HandleFunc(http.MethodGet, "/healthz", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		method, path string
+		status       int
+		// In the body, or the Location header for redirects.
+		want string
+	}{
+		{http.MethodGet, "/", http.StatusOK, "<form"},
+		{http.MethodGet, "/favicon.ico", http.StatusOK, "<form"},
+		{http.MethodPost, "/no/such/page", http.StatusOK, "<form"},
+		{http.MethodPost, "/", http.StatusOK, "<form"},
+		{http.MethodPut, "/healthz", http.StatusMethodNotAllowed, "PUT isn&#39;t allowed here"},
+		{http.MethodGet, "/user/alice", http.StatusOK, "Welcome alice!"},
+		// The user is the leading word characters of the path, as with the
+		// old `^/user/(\w+)` match.
+		{http.MethodGet, "/user/alice/anything", http.StatusOK, "Welcome alice!"},
+		{http.MethodGet, "/user/alice-bob", http.StatusOK, "Welcome alice!"},
+		{http.MethodPost, "/user/alice", http.StatusOK, "Welcome alice!"},
+		{http.MethodGet, "/user/!alice", http.StatusNotFound, "no such user"},
+		{http.MethodGet, "/user?user=alice", http.StatusFound, "/user/alice"},
+		{http.MethodGet, "/publish?user=alice&text=hi", http.StatusFound, "/user/alice"},
+		{http.MethodPost, "/publish?user=nobody&text=hi", http.StatusNotFound, "user not found"},
+		{http.MethodPut, "/publish?user=alice&text=hi", http.StatusFound, "/user/alice"},
+		{http.MethodDelete, "/follow?src=alice&dst=bob", http.StatusFound, "/user/alice"},
+		{http.MethodGet, "/follow?src=alice&dst=bob", http.StatusFound, "/user/alice"},
+		{http.MethodGet, "/follow?src=alice", http.StatusSeeOther, "/"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if got, want := w.Code, test.status; got != want {
+			t.Errorf("%s %s: Got %v, want %v", test.method, test.path, got, want)
+		}
+		got := w.Body.String()
+		if w.Code >= 300 && w.Code < 400 {
+			got = w.Header().Get("Location")
+		}
+		if !strings.Contains(got, test.want) {
+			t.Errorf("%s %s: Got %q, want it to contain %q", test.method, test.path, got, test.want)
+		}
+	}
+}
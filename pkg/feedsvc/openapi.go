@@ -0,0 +1,174 @@
+package feedsvc
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// The OpenAPI document is generated from apiRoutes() and the Go types of the
+// request and response bodies, so it can't drift from the handlers.
+func buildOpenAPI(routes []apiRoute) map[string]interface{} {
+	paths := make(map[string]interface{})
+	for _, route := range routes {
+		// Routes without a response type aren't wrapped in the envelope.
+		respSchema := map[string]interface{}{"type": "object"}
+		if route.response != nil {
+			respSchema = envelopeSchema(jsonSchema(reflect.TypeOf(route.response)))
+		}
+
+		op := map[string]interface{}{
+			"summary":     route.summary,
+			"operationId": operationID(route),
+			"responses": map[string]interface{}{
+				fmt.Sprint(route.status): map[string]interface{}{
+					"description": http.StatusText(route.status),
+					"content":     jsonContent(respSchema),
+				},
+				"default": map[string]interface{}{
+					"description": "Error",
+					"content":     jsonContent(map[string]interface{}{"$ref": "#/components/schemas/Error"}),
+				},
+			},
+		}
+
+		if params := pathParams(route.path); len(params) > 0 {
+			list := make([]interface{}, 0, len(params))
+			for _, p := range params {
+				list = append(list, map[string]interface{}{
+					"name":     p,
+					"in":       "path",
+					"required": true,
+					"schema":   map[string]interface{}{"type": "string"},
+				})
+			}
+			op["parameters"] = list
+		}
+
+		if route.request != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(jsonSchema(reflect.TypeOf(route.request))),
+			}
+		}
+
+		path := apiPrefix + route.path
+		methods, ok := paths[path].(map[string]interface{})
+		if !ok {
+			methods = make(map[string]interface{})
+			paths[path] = methods
+		}
+		methods[strings.ToLower(route.method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "PlaneChat API",
+			"version": "v1",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"Error": envelopeErrorSchema(),
+			},
+		},
+	}
+}
+
+func operationID(route apiRoute) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(route.method))
+	for _, part := range strings.Split(route.path, "/") {
+		part = strings.Trim(part, "{}")
+		part = strings.TrimSuffix(part, ".json")
+		if part == "" {
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+func pathParams(path string) []string {
+	params := make([]string, 0)
+	for _, part := range strings.Split(path, "/") {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			params = append(params, part[1:len(part)-1])
+		}
+	}
+	return params
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+func envelopeSchema(data map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"data": data},
+	}
+}
+
+func envelopeErrorSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"error": jsonSchema(reflect.TypeOf(apiError{})),
+		},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Only covers the kinds of types that the API actually sends.
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonSchema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object"}
+	case reflect.Struct:
+		props := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name := f.Name
+			if tag := f.Tag.Get("json"); tag != "" {
+				if tag == "-" {
+					continue
+				}
+				if n := strings.Split(tag, ",")[0]; n != "" {
+					name = n
+				}
+			}
+			props[name] = jsonSchema(f.Type)
+		}
+		return map[string]interface{}{"type": "object", "properties": props}
+	default:
+		return map[string]interface{}{}
+	}
+}
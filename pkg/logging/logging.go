@@ -0,0 +1,289 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	stdlog "log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"holosam/appengine/demo/pkg/trace"
+)
+
+const (
+	// json or text. Defaults to json on App Engine and text everywhere else.
+	EnvLogFormat = "LOG_FORMAT"
+	// The level for every logger that isn't in LOG_LEVELS. Defaults to info.
+	EnvLogLevel = "LOG_LEVEL"
+	// Per-logger levels, like "database=debug,util=warning".
+	EnvLogLevels = "LOG_LEVELS"
+
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+type Severity int
+
+// Named like Cloud Logging's severities.
+const (
+	Debug Severity = iota
+	Info
+	Warning
+	Error
+	Critical
+)
+
+var severityNames = []string{"DEBUG", "INFO", "WARNING", "ERROR", "CRITICAL"}
+
+func (s Severity) String() string {
+	if s < Debug || s > Critical {
+		return "DEFAULT"
+	}
+	return severityNames[s]
+}
+
+func ParseSeverity(v string) (Severity, bool) {
+	v = strings.ToUpper(strings.TrimSpace(v))
+	if v == "WARN" {
+		v = "WARNING"
+	}
+	for i, name := range severityNames {
+		if name == v {
+			return Severity(i), true
+		}
+	}
+	return Info, false
+}
+
+type Config struct {
+	Format string
+	Level  Severity
+	// By logger name, overriding Level.
+	Levels map[string]Severity
+	// Used to build the full trace resource name that Cloud Logging wants.
+	Project string
+}
+
+// Reads the LOG_* variables, and GOOGLE_CLOUD_PROJECT for the project.
+// Anything invalid is reported on stderr and skipped.
+func ConfigFromEnv() Config {
+	c := Config{
+		Format:  FormatText,
+		Level:   Info,
+		Levels:  make(map[string]Severity),
+		Project: os.Getenv("GOOGLE_CLOUD_PROJECT"),
+	}
+	if os.Getenv("GAE_ENV") != "" {
+		c.Format = FormatJSON
+	}
+
+	switch f := os.Getenv(EnvLogFormat); f {
+	case "":
+	case FormatJSON, FormatText:
+		c.Format = f
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid %s %q, using %s\n", EnvLogFormat, f, c.Format)
+	}
+
+	if v := os.Getenv(EnvLogLevel); v != "" {
+		if level, ok := ParseSeverity(v); ok {
+			c.Level = level
+		} else {
+			fmt.Fprintf(os.Stderr, "Invalid %s %q, using %v\n", EnvLogLevel, v, c.Level)
+		}
+	}
+
+	for _, pair := range strings.Split(os.Getenv(EnvLogLevels), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		level, ok := Info, false
+		if len(kv) == 2 {
+			level, ok = ParseSeverity(kv[1])
+		}
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Invalid %s entry %q, skipping\n", EnvLogLevels, pair)
+			continue
+		}
+		c.Levels[strings.TrimSpace(kv[0])] = level
+	}
+	return c
+}
+
+var (
+	mu         sync.Mutex
+	configured bool
+	config     Config
+	out        io.Writer = os.Stdout
+)
+
+// Replaces the config from the environment, which is otherwise loaded on
+// first use.
+func Configure(c Config) {
+	mu.Lock()
+	defer mu.Unlock()
+	config = c
+	configured = true
+}
+
+// Stdout by default, which is where App Engine picks up structured logs.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	out = w
+}
+
+// Must be called with mu held.
+func currentConfig() Config {
+	if !configured {
+		config = ConfigFromEnv()
+		configured = true
+	}
+	return config
+}
+
+// A named logger, usually one per package. The name is what LOG_LEVELS
+// refers to.
+type Logger struct {
+	name string
+}
+
+func New(name string) *Logger {
+	return &Logger{name: name}
+}
+
+func (l *Logger) Enabled(s Severity) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return s >= l.level(currentConfig())
+}
+
+func (l *Logger) level(c Config) Severity {
+	if level, ok := c.Levels[l.name]; ok {
+		return level
+	}
+	return c.Level
+}
+
+func (l *Logger) Debugf(ctx context.Context, format string, args ...interface{}) {
+	l.Log(ctx, Debug, format, args...)
+}
+
+func (l *Logger) Infof(ctx context.Context, format string, args ...interface{}) {
+	l.Log(ctx, Info, format, args...)
+}
+
+func (l *Logger) Warningf(ctx context.Context, format string, args ...interface{}) {
+	l.Log(ctx, Warning, format, args...)
+}
+
+func (l *Logger) Errorf(ctx context.Context, format string, args ...interface{}) {
+	l.Log(ctx, Error, format, args...)
+}
+
+// Logs at Critical and exits.
+func (l *Logger) Fatalf(ctx context.Context, format string, args ...interface{}) {
+	l.Log(ctx, Critical, format, args...)
+	os.Exit(1)
+}
+
+// https://cloud.google.com/logging/docs/structured-logging#special-payload-fields
+type entry struct {
+	Severity     string `json:"severity"`
+	Message      string `json:"message"`
+	Time         string `json:"time"`
+	Logger       string `json:"logger,omitempty"`
+	RequestID    string `json:"requestId,omitempty"`
+	User         string `json:"user,omitempty"`
+	Trace        string `json:"logging.googleapis.com/trace,omitempty"`
+	SpanID       string `json:"logging.googleapis.com/spanId,omitempty"`
+	TraceSampled bool   `json:"logging.googleapis.com/trace_sampled,omitempty"`
+}
+
+func (l *Logger) Log(ctx context.Context, s Severity, format string, args ...interface{}) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	c := currentConfig()
+	if s < l.level(c) {
+		return
+	}
+
+	e := entry{
+		Severity:  s.String(),
+		Message:   fmt.Sprintf(format, args...),
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		Logger:    l.name,
+		RequestID: RequestID(ctx),
+		User:      User(ctx),
+	}
+	traceID, spanID, sampled := traceFrom(ctx)
+	if traceID != "" {
+		e.Trace = traceID
+		if c.Project != "" {
+			e.Trace = fmt.Sprintf("projects/%s/traces/%s", c.Project, traceID)
+		}
+		e.SpanID = spanID
+		e.TraceSampled = sampled
+	}
+
+	if c.Format == FormatJSON {
+		b, err := json.Marshal(e)
+		if err != nil {
+			fmt.Fprintf(out, `{"severity":"ERROR","message":%q}`+"\n", "Log encode error: "+err.Error())
+			return
+		}
+		out.Write(append(b, '\n'))
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %-7s [%s] %s", time.Now().Format("2006/01/02 15:04:05"), e.Severity, e.Logger, e.Message)
+	if e.RequestID != "" {
+		fmt.Fprintf(&b, " request_id=%s", e.RequestID)
+	}
+	if e.User != "" {
+		fmt.Fprintf(&b, " user=%s", e.User)
+	}
+	if traceID != "" {
+		fmt.Fprintf(&b, " trace=%s", traceID)
+	}
+	b.WriteByte('\n')
+	io.WriteString(out, b.String())
+}
+
+// Prefers the trace App Engine's frontend started, so logs line up with its
+// request log, and falls back to the span in ctx.
+func traceFrom(ctx context.Context) (traceID, spanID string, sampled bool) {
+	if r := requestFrom(ctx); r != nil && r.traceID != "" {
+		return r.traceID, r.spanID, r.sampled
+	}
+	if sc := trace.FromContext(ctx).Context(); sc.IsValid() {
+		return sc.TraceID, sc.SpanID, sc.Sampled
+	}
+	return "", "", false
+}
+
+// Sends everything written with the standard log package through a logger,
+// for the libraries that use it.
+func RedirectStdLog(l *Logger) {
+	stdlog.SetFlags(0)
+	stdlog.SetOutput(stdWriter{l})
+}
+
+type stdWriter struct {
+	l *Logger
+}
+
+func (w stdWriter) Write(b []byte) (int, error) {
+	w.l.Infof(context.Background(), "%s", strings.TrimSuffix(string(b), "\n"))
+	return len(b), nil
+}
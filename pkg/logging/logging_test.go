@@ -0,0 +1,87 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestJSONEntry(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	Configure(Config{Format: FormatJSON, Level: Info, Project: "demo"})
+
+	header := http.Header{}
+	header.Set(HeaderCloudTraceContext, "105445aa7843bc8bf206b12000100000/1;o=1")
+	ctx := WithRequest(context.Background(), "req-1", header)
+	SetUser(ctx, "bob")
+	New("test").Warningf(ctx, "Hello %s", "there")
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Got %v decoding %q", err, buf.String())
+	}
+	want := map[string]interface{}{
+		"severity":                             "WARNING",
+		"message":                              "Hello there",
+		"logger":                               "test",
+		"requestId":                            "req-1",
+		"user":                                 "bob",
+		"logging.googleapis.com/trace":         "projects/demo/traces/105445aa7843bc8bf206b12000100000",
+		"logging.googleapis.com/spanId":        "0000000000000001",
+		"logging.googleapis.com/trace_sampled": true,
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("Got %s=%v, want %v", k, got[k], v)
+		}
+	}
+}
+
+func TestLevels(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	Configure(Config{
+		Format: FormatText,
+		Level:  Warning,
+		Levels: map[string]Severity{"database": Debug},
+	})
+
+	ctx := context.Background()
+	New("util").Infof(ctx, "dropped")
+	New("util").Errorf(ctx, "kept error")
+	New("database").Debugf(ctx, "kept debug")
+
+	out := buf.String()
+	if strings.Contains(out, "dropped") {
+		t.Errorf("Got %q, want no info lines from util", out)
+	}
+	if !strings.Contains(out, "ERROR   [util] kept error") || !strings.Contains(out, "DEBUG   [database] kept debug") {
+		t.Errorf("Got %q, want the error and debug lines", out)
+	}
+}
+
+func TestParseCloudTraceContext(t *testing.T) {
+	cases := []struct {
+		in      string
+		trace   string
+		span    string
+		sampled bool
+	}{
+		{"105445aa7843bc8bf206b12000100000/255;o=1", "105445aa7843bc8bf206b12000100000", "00000000000000ff", true},
+		{"105445aa7843bc8bf206b12000100000/255;o=0", "105445aa7843bc8bf206b12000100000", "00000000000000ff", false},
+		{"105445aa7843bc8bf206b12000100000", "105445aa7843bc8bf206b12000100000", "", false},
+		{"tooshort/1;o=1", "", "", false},
+		{"", "", "", false},
+	}
+
+	for _, c := range cases {
+		trace, span, sampled := ParseCloudTraceContext(c.in)
+		if trace != c.trace || span != c.span || sampled != c.sampled {
+			t.Errorf("Got %q, %q, %v for %q, want %q, %q, %v", trace, span, sampled, c.in, c.trace, c.span, c.sampled)
+		}
+	}
+}
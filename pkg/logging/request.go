@@ -0,0 +1,90 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Set by App Engine's frontend, as TRACE_ID/SPAN_ID;o=OPTIONS with a decimal
+// span ID.
+const HeaderCloudTraceContext = "X-Cloud-Trace-Context"
+
+type requestKey struct{}
+
+// The request-scoped fields that go on every log line.
+type request struct {
+	id      string
+	traceID string
+	spanID  string
+	sampled bool
+
+	mu   sync.Mutex
+	user string
+}
+
+// Starts attaching the request ID, and the trace from header if it has an
+// X-Cloud-Trace-Context, to everything logged with the returned context.
+func WithRequest(ctx context.Context, id string, header http.Header) context.Context {
+	r := &request{id: id}
+	r.traceID, r.spanID, r.sampled = ParseCloudTraceContext(header.Get(HeaderCloudTraceContext))
+	return context.WithValue(ctx, requestKey{}, r)
+}
+
+func requestFrom(ctx context.Context) *request {
+	r, _ := ctx.Value(requestKey{}).(*request)
+	return r
+}
+
+func RequestID(ctx context.Context) string {
+	if r := requestFrom(ctx); r != nil {
+		return r.id
+	}
+	return ""
+}
+
+// Can be called once the handler knows who the request is for, and
+// everything logged after that includes it.
+func SetUser(ctx context.Context, user string) {
+	if r := requestFrom(ctx); r != nil {
+		r.mu.Lock()
+		r.user = user
+		r.mu.Unlock()
+	}
+}
+
+func User(ctx context.Context) string {
+	if r := requestFrom(ctx); r != nil {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return r.user
+	}
+	return ""
+}
+
+// Returns the span ID as 16 hex characters, which is what Cloud Logging wants
+// in the spanId field. Everything is empty if v isn't valid.
+func ParseCloudTraceContext(v string) (traceID, spanID string, sampled bool) {
+	if v == "" {
+		return "", "", false
+	}
+
+	opts := ""
+	if i := strings.Index(v, ";"); i >= 0 {
+		v, opts = v[:i], v[i+1:]
+	}
+	traceID = v
+	if i := strings.Index(v, "/"); i >= 0 {
+		traceID = v[:i]
+		if id, err := strconv.ParseUint(v[i+1:], 10, 64); err == nil {
+			spanID = fmt.Sprintf("%016x", id)
+		}
+	}
+	if len(traceID) != 32 {
+		return "", "", false
+	}
+	return strings.ToLower(traceID), spanID, opts == "o=1"
+}
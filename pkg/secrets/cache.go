@@ -0,0 +1,136 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Keeps every secret that's been read, and calls the rotation callbacks when
+// a refresh finds a new value.
+type Cache struct {
+	backend Secrets
+
+	mu      sync.RWMutex
+	values  map[string][]byte
+	rotated map[string][]func(value []byte)
+}
+
+func NewCache(backend Secrets) *Cache {
+	return &Cache{
+		backend: backend,
+		values:  make(map[string][]byte),
+		rotated: make(map[string][]func(value []byte)),
+	}
+}
+
+func (c *Cache) Get(ctx context.Context, name string) ([]byte, error) {
+	c.mu.RLock()
+	value, ok := c.values[name]
+	c.mu.RUnlock()
+	if ok {
+		return value, nil
+	}
+
+	value, err := c.backend.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[name] = value
+	return value, nil
+}
+
+// Like Get, but a missing secret is nil instead of an error.
+func (c *Cache) Optional(ctx context.Context, name string) ([]byte, error) {
+	value, err := c.Get(ctx, name)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return value, err
+}
+
+// Like Get, but an empty value is ErrNotFound too.
+func (c *Cache) Required(ctx context.Context, name string) ([]byte, error) {
+	value, err := c.Get(ctx, name)
+	if err == nil && len(value) == 0 {
+		return nil, fmt.Errorf("%s is empty: %w", name, ErrNotFound)
+	}
+	return value, err
+}
+
+// Calls f with the new value whenever Refresh finds name has changed,
+// including when it shows up for the first time.
+func (c *Cache) OnRotate(name string, f func(value []byte)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rotated[name] = append(c.rotated[name], f)
+}
+
+// Re-reads every secret that's been read or has a callback. One that fails or
+// has gone missing keeps its last value, so a backend hiccup doesn't unset a
+// key.
+func (c *Cache) Refresh(ctx context.Context) error {
+	c.mu.RLock()
+	names := make(map[string]bool, len(c.values)+len(c.rotated))
+	for name := range c.values {
+		names[name] = true
+	}
+	for name := range c.rotated {
+		names[name] = true
+	}
+	c.mu.RUnlock()
+
+	problems := make([]string, 0)
+	for name := range names {
+		value, err := c.backend.Get(ctx, name)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+
+		c.mu.Lock()
+		old, ok := c.values[name]
+		changed := !ok || !bytes.Equal(old, value)
+		c.values[name] = value
+		callbacks := c.rotated[name]
+		c.mu.Unlock()
+
+		if changed && len(callbacks) > 0 {
+			logger.Infof(ctx, "Secret %s rotated", name)
+			for _, f := range callbacks {
+				f(value)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("refresh secrets error: %v", problems)
+	}
+	return nil
+}
+
+// Refreshes every interval until ctx ends.
+func (c *Cache) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Refresh(ctx); err != nil {
+				logger.Warningf(ctx, "Keeping the current secrets: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	KeySize = 32
+	// Bound into the ciphertext, so the format can change later.
+	fileVersion = "secrets-v1"
+)
+
+// What's on disk. The plaintext is the secrets as a JSON object.
+type encryptedFile struct {
+	Version string `json:"version"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// An AES-256 key, base64 encoded like GenerateKey makes.
+func ParseKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("secrets key should be %d bytes, base64 encoded", KeySize)
+	}
+	return key, nil
+}
+
+func GenerateKey() (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("generate key error: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// Secrets from a file written by WriteEncrypted. It's read again on every
+// Get, which the cache in front of it keeps rare.
+func EncryptedFile(path string, key []byte) Secrets {
+	return SecretsFunc(func(ctx context.Context, name string) ([]byte, error) {
+		values, err := ReadEncrypted(path, key)
+		if err != nil {
+			return nil, err
+		}
+		v, ok := values[name]
+		if !ok {
+			return nil, ErrNotFound
+		}
+		return []byte(v), nil
+	})
+}
+
+// All the secrets in the file. A missing file is an error that matches
+// os.ErrNotExist, not an empty store, since a service pointed at the wrong
+// path would otherwise run without its keys. cmd/secrets set creates it.
+func ReadEncrypted(path string, key []byte) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read secrets file error: %w", err)
+	}
+
+	var f encryptedFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parse secrets file error: %w", err)
+	}
+	if f.Version != fileVersion {
+		return nil, fmt.Errorf("secrets file version %q isn't %s", f.Version, fileVersion)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, f.Nonce, f.Data, []byte(fileVersion))
+	if err != nil {
+		return nil, errors.New("decrypt secrets file error: wrong key or a damaged file")
+	}
+
+	values := make(map[string]string)
+	if err := json.Unmarshal(plaintext, &values); err != nil {
+		return nil, fmt.Errorf("parse secrets error: %w", err)
+	}
+	return values, nil
+}
+
+// Replaces the file with values, through a temp file so a reader never sees
+// half of it.
+func WriteEncrypted(path string, key []byte, values map[string]string) error {
+	plaintext, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("encode secrets error: %w", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("generate nonce error: %w", err)
+	}
+
+	data, err := json.MarshalIndent(encryptedFile{
+		Version: fileVersion,
+		Nonce:   nonce,
+		Data:    gcm.Seal(nil, nonce, plaintext, []byte(fileVersion)),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode secrets file error: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("write secrets file error: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write secrets file error: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write secrets file error: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("write secrets file error: %w", err)
+	}
+	return nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("secrets key error: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,132 @@
+// Secrets like signing keys, kept out of app.yaml. They come from one of three
+// backends: the environment, a directory with a file per secret (like a
+// mounted volume), or a local file encrypted with a key from cmd/secrets.
+// Values are cached, and re-read every SECRETS_REFRESH so a rotated secret is
+// picked up without a restart.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"holosam/appengine/demo/pkg/logging"
+)
+
+const (
+	BackendEnv       = "env"
+	BackendFile      = "file"
+	BackendEncrypted = "encrypted"
+)
+
+var (
+	ErrNotFound = errors.New("secret not found")
+
+	logger = logging.New("secrets")
+)
+
+// Get returns ErrNotFound when the backend doesn't have the secret.
+type Secrets interface {
+	Get(ctx context.Context, name string) ([]byte, error)
+}
+
+type SecretsFunc func(ctx context.Context, name string) ([]byte, error)
+
+func (f SecretsFunc) Get(ctx context.Context, name string) ([]byte, error) {
+	return f(ctx, name)
+}
+
+type Config struct {
+	Backend string `env:"SECRETS_BACKEND" default:"env" oneof:"env|file|encrypted"`
+	// For file.
+	Dir string `env:"SECRETS_DIR"`
+	// For encrypted, the file and its key from cmd/secrets, either as is or
+	// in a file.
+	File    string        `env:"SECRETS_FILE"`
+	Key     string        `env:"SECRETS_KEY" secret:"true"`
+	KeyFile string        `env:"SECRETS_KEY_FILE"`
+	Refresh time.Duration `env:"SECRETS_REFRESH" default:"5m" min:"1s"`
+}
+
+func (c *Config) Validate() error {
+	switch c.Backend {
+	case BackendFile:
+		if c.Dir == "" {
+			return errors.New("SECRETS_DIR is required when SECRETS_BACKEND is file")
+		}
+	case BackendEncrypted:
+		if c.File == "" {
+			return errors.New("SECRETS_FILE is required when SECRETS_BACKEND is encrypted")
+		}
+		if (c.Key == "") == (c.KeyFile == "") {
+			return errors.New("one of SECRETS_KEY and SECRETS_KEY_FILE is required when SECRETS_BACKEND is encrypted")
+		}
+	}
+	return nil
+}
+
+// The configured backend, cached.
+func Open(cfg Config) (*Cache, error) {
+	var backend Secrets
+	switch cfg.Backend {
+	case BackendEnv:
+		backend = Env()
+	case BackendFile:
+		backend = Dir(cfg.Dir)
+	case BackendEncrypted:
+		encoded := cfg.Key
+		if cfg.KeyFile != "" {
+			data, err := os.ReadFile(cfg.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("read secrets key error: %w", err)
+			}
+			encoded = string(data)
+		}
+		key, err := ParseKey(encoded)
+		if err != nil {
+			return nil, err
+		}
+		// Fails now on a missing file or the wrong key, rather than on the
+		// first Get.
+		if _, err := ReadEncrypted(cfg.File, key); err != nil {
+			return nil, err
+		}
+		backend = EncryptedFile(cfg.File, key)
+	default:
+		return nil, fmt.Errorf("unknown secrets backend %q", cfg.Backend)
+	}
+	return NewCache(backend), nil
+}
+
+// Secrets are environment variables of the same name.
+func Env() Secrets {
+	return SecretsFunc(func(ctx context.Context, name string) ([]byte, error) {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, ErrNotFound
+		}
+		return []byte(v), nil
+	})
+}
+
+// Secrets are files of the same name in dir. A trailing newline isn't part of
+// the secret, since most editors add one.
+func Dir(dir string) Secrets {
+	return SecretsFunc(func(ctx context.Context, name string) ([]byte, error) {
+		if name == "" || strings.ContainsAny(name, `/\`) || name[0] == '.' {
+			return nil, fmt.Errorf("invalid secret name %q", name)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read secret error: %w", err)
+		}
+		return []byte(strings.TrimRight(string(data), "\r\n")), nil
+	})
+}
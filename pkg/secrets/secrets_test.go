@@ -0,0 +1,125 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestCacheRotation(t *testing.T) {
+	var mu sync.Mutex
+	values := map[string]string{"FLASH_KEY": "one"}
+	cache := NewCache(SecretsFunc(func(ctx context.Context, name string) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		v, ok := values[name]
+		if !ok {
+			return nil, ErrNotFound
+		}
+		return []byte(v), nil
+	}))
+	ctx := context.Background()
+
+	rotated := make([]string, 0)
+	cache.OnRotate("FLASH_KEY", func(value []byte) { rotated = append(rotated, string(value)) })
+	if v, err := cache.Get(ctx, "FLASH_KEY"); err != nil || string(v) != "one" {
+		t.Errorf("Got %q, %v, want one", v, err)
+	}
+	if _, err := cache.Get(ctx, "OTHER"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Got %v for a missing secret, want ErrNotFound", err)
+	}
+
+	mu.Lock()
+	values["FLASH_KEY"] = "two"
+	mu.Unlock()
+	cache.Refresh(ctx)
+	// Unchanged, so no callback.
+	cache.Refresh(ctx)
+	// Gone from the backend, so the last value stays.
+	mu.Lock()
+	delete(values, "FLASH_KEY")
+	mu.Unlock()
+	cache.Refresh(ctx)
+
+	if v, _ := cache.Get(ctx, "FLASH_KEY"); string(v) != "two" {
+		t.Errorf("Got %q after rotation, want two", v)
+	}
+	if want := []string{"two"}; !reflect.DeepEqual(rotated, want) {
+		t.Errorf("Got rotations %v, want %v", rotated, want)
+	}
+}
+
+func TestEncryptedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.enc")
+	encoded, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseKey(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteEncrypted(path, key, map[string]string{"SERVICE_HMAC_KEY": "shh"}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := EncryptedFile(path, key).Get(context.Background(), "SERVICE_HMAC_KEY"); err != nil || string(v) != "shh" {
+		t.Errorf("Got %q, %v, want shh", v, err)
+	}
+
+	other, _ := GenerateKey()
+	otherKey, _ := ParseKey(other)
+	if _, err := ReadEncrypted(path, otherKey); err == nil {
+		t.Errorf("Got nil reading with another key, want an error")
+	}
+}
+
+func TestEncryptedFileMissing(t *testing.T) {
+	encoded, _ := GenerateKey()
+	path := filepath.Join(t.TempDir(), "secrets.enc")
+
+	if _, err := ReadEncrypted(path, nil); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Got %v reading a missing file, want os.ErrNotExist", err)
+	}
+	if _, err := Open(Config{Backend: BackendEncrypted, File: path, Key: encoded}); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Got %v opening a missing file, want os.ErrNotExist", err)
+	}
+}
+
+func TestCacheRequired(t *testing.T) {
+	cache := NewCache(SecretsFunc(func(ctx context.Context, name string) ([]byte, error) {
+		if name == "EMPTY" {
+			return []byte{}, nil
+		}
+		return nil, ErrNotFound
+	}))
+	ctx := context.Background()
+
+	for _, name := range []string{"EMPTY", "MISSING"} {
+		if _, err := cache.Required(ctx, name); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Got %v for %s, want ErrNotFound", err, name)
+		}
+		if v, err := cache.Optional(ctx, name); err != nil || len(v) != 0 {
+			t.Errorf("Got %q, %v from Optional for %s, want nothing", v, err, name)
+		}
+	}
+}
+
+func TestDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "FLASH_KEY"), []byte("shh\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if v, err := Dir(dir).Get(ctx, "FLASH_KEY"); err != nil || string(v) != "shh" {
+		t.Errorf("Got %q, %v, want shh", v, err)
+	}
+	if _, err := Dir(dir).Get(ctx, "../FLASH_KEY"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Got %v for a path, want an invalid name error", err)
+	}
+}
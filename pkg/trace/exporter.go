@@ -0,0 +1,320 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Exporter interface {
+	// Called once for every finished span, so it shouldn't block.
+	Export(span *SpanData)
+	// Flushes anything buffered.
+	Shutdown(ctx context.Context) error
+}
+
+// Writes one JSON line per span, for local use.
+type StdoutExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewStdoutExporter(w io.Writer) *StdoutExporter {
+	return &StdoutExporter{w: w}
+}
+
+func (e *StdoutExporter) Export(span *SpanData) {
+	b, err := json.Marshal(span)
+	if err != nil {
+		log.Printf("Span encode error: %v", err)
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.w.Write(append(b, '\n'))
+}
+
+func (e *StdoutExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// Keeps spans in memory so tests can look at them.
+type MemoryExporter struct {
+	mu    sync.Mutex
+	spans []*SpanData
+}
+
+func NewMemoryExporter() *MemoryExporter {
+	return &MemoryExporter{}
+}
+
+func (e *MemoryExporter) Export(span *SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+func (e *MemoryExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// In the order they ended.
+func (e *MemoryExporter) Spans() []*SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*SpanData(nil), e.spans...)
+}
+
+func (e *MemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+// Renders the spans as an indented tree of names, with children in start
+// order, which is easy to compare in tests:
+//
+//	GET /user/{user}
+//	  DBClient.ModifyUser
+//	  DBClient.GetUserDocs
+func (e *MemoryExporter) Tree() string {
+	spans := e.Spans()
+	children := make(map[string][]*SpanData)
+	ids := make(map[string]bool)
+	for _, s := range spans {
+		ids[s.SpanID] = true
+	}
+
+	roots := make([]*SpanData, 0)
+	for _, s := range spans {
+		if s.ParentID == "" || !ids[s.ParentID] {
+			roots = append(roots, s)
+		} else {
+			children[s.ParentID] = append(children[s.ParentID], s)
+		}
+	}
+
+	var b strings.Builder
+	var walk func(list []*SpanData, depth int)
+	walk = func(list []*SpanData, depth int) {
+		sortByStart(list)
+		for _, s := range list {
+			b.WriteString(strings.Repeat("  ", depth) + s.Name + "\n")
+			walk(children[s.SpanID], depth+1)
+		}
+	}
+	walk(roots, 0)
+	return b.String()
+}
+
+func sortByStart(spans []*SpanData) {
+	for i := 1; i < len(spans); i++ {
+		for j := i; j > 0 && spans[j].Start.Before(spans[j-1].Start); j-- {
+			spans[j], spans[j-1] = spans[j-1], spans[j]
+		}
+	}
+}
+
+const (
+	otlpBatchSize     = 100
+	otlpFlushInterval = 5 * time.Second
+	otlpQueueSize     = 2048
+)
+
+// Sends batches of spans to an OTLP/HTTP collector, in the JSON encoding.
+// Spans are dropped if the queue is full, rather than slowing requests down.
+type OTLPExporter struct {
+	url    string
+	client *http.Client
+
+	queue chan *SpanData
+	flush chan chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+// endpoint is the collector's base URL, like http://localhost:4318.
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	e := &OTLPExporter{
+		url:    strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan *SpanData, otlpQueueSize),
+		flush:  make(chan chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *OTLPExporter) Export(span *SpanData) {
+	select {
+	case e.queue <- span:
+	default:
+		// Dropped.
+	}
+}
+
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case e.flush <- flushed:
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-flushed:
+		e.once.Do(func() { close(e.done) })
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *OTLPExporter) run() {
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*SpanData, 0, otlpBatchSize)
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			log.Printf("OTLP export of %d spans failed: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= otlpBatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case flushed := <-e.flush:
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+			}
+			send()
+			close(flushed)
+		case <-e.done:
+			return
+		}
+	}
+}
+
+func (e *OTLPExporter) send(spans []*SpanData) error {
+	body, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned HTTP status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+func otlpRequest(spans []*SpanData) map[string]interface{} {
+	byService := make(map[string][]interface{})
+	order := make([]string, 0)
+	for _, s := range spans {
+		if _, ok := byService[s.Service]; !ok {
+			order = append(order, s.Service)
+		}
+		byService[s.Service] = append(byService[s.Service], otlpSpan(s))
+	}
+
+	resourceSpans := make([]interface{}, 0, len(order))
+	for _, svc := range order {
+		resourceSpans = append(resourceSpans, map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []interface{}{otlpAttr("service.name", svc)},
+			},
+			"scopeSpans": []interface{}{
+				map[string]interface{}{
+					"scope": map[string]interface{}{"name": "holosam/appengine/demo/pkg/trace"},
+					"spans": byService[svc],
+				},
+			},
+		})
+	}
+	return map[string]interface{}{"resourceSpans": resourceSpans}
+}
+
+func otlpSpan(s *SpanData) map[string]interface{} {
+	kind := 1 // SPAN_KIND_INTERNAL
+	switch s.Kind {
+	case KindServer:
+		kind = 2
+	case KindClient:
+		kind = 3
+	}
+
+	attrs := make([]interface{}, 0, len(s.Attributes))
+	for k, v := range s.Attributes {
+		attrs = append(attrs, otlpAttr(k, v))
+	}
+
+	status := map[string]interface{}{"code": 1} // STATUS_CODE_OK
+	if s.Error != "" {
+		status = map[string]interface{}{"code": 2, "message": s.Error}
+	}
+
+	span := map[string]interface{}{
+		"traceId":           s.TraceID,
+		"spanId":            s.SpanID,
+		"name":              s.Name,
+		"kind":              kind,
+		"startTimeUnixNano": strconv.FormatInt(s.Start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.End.UnixNano(), 10),
+		"attributes":        attrs,
+		"status":            status,
+	}
+	if s.ParentID != "" {
+		span["parentSpanId"] = s.ParentID
+	}
+	return span
+}
+
+func otlpAttr(key string, value interface{}) map[string]interface{} {
+	var v map[string]interface{}
+	switch val := value.(type) {
+	case string:
+		v = map[string]interface{}{"stringValue": val}
+	case bool:
+		v = map[string]interface{}{"boolValue": val}
+	case int:
+		v = map[string]interface{}{"intValue": strconv.Itoa(val)}
+	case int64:
+		v = map[string]interface{}{"intValue": strconv.FormatInt(val, 10)}
+	case float64:
+		v = map[string]interface{}{"doubleValue": val}
+	default:
+		v = map[string]interface{}{"stringValue": fmt.Sprint(val)}
+	}
+	return map[string]interface{}{"key": key, "value": v}
+}
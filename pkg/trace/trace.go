@@ -0,0 +1,268 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const HeaderTraceparent = "traceparent"
+
+const (
+	KindInternal = "internal"
+	KindServer   = "server"
+	KindClient   = "client"
+)
+
+var (
+	mu       sync.RWMutex
+	exporter Exporter
+	service  = "unknown"
+)
+
+// Spans are always created so the trace context is propagated, but they're
+// only exported if an exporter is set.
+func SetExporter(e Exporter) {
+	mu.Lock()
+	defer mu.Unlock()
+	exporter = e
+}
+
+func SetServiceName(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	service = name
+}
+
+// Identifies a span across processes, as carried by the traceparent header.
+type SpanContext struct {
+	TraceID string
+	SpanID  string
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return len(sc.TraceID) == 32 && len(sc.SpanID) == 16
+}
+
+type Span struct {
+	sc       SpanContext
+	parentID string
+	name     string
+	kind     string
+	start    time.Time
+
+	mu    sync.Mutex
+	attrs map[string]interface{}
+	err   string
+	ended bool
+}
+
+// The exported form of a finished span.
+type SpanData struct {
+	Service    string                 `json:"service"`
+	TraceID    string                 `json:"traceId"`
+	SpanID     string                 `json:"spanId"`
+	ParentID   string                 `json:"parentId,omitempty"`
+	Name       string                 `json:"name"`
+	Kind       string                 `json:"kind"`
+	Start      time.Time              `json:"start"`
+	End        time.Time              `json:"end"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+func (d *SpanData) Duration() time.Duration {
+	return d.End.Sub(d.Start)
+}
+
+type spanKey struct{}
+
+// Starts a span that's a child of the span in ctx, if there is one.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	return startSpan(ctx, name, KindInternal, spanContextFrom(ctx))
+}
+
+// Starts a span of the given kind. The parent is the span in ctx, or remote if
+// ctx has no span.
+func StartWithKind(ctx context.Context, name, kind string, remote SpanContext) (context.Context, *Span) {
+	parent := spanContextFrom(ctx)
+	if !parent.IsValid() {
+		parent = remote
+	}
+	return startSpan(ctx, name, kind, parent)
+}
+
+func startSpan(ctx context.Context, name, kind string, parent SpanContext) (context.Context, *Span) {
+	s := &Span{
+		sc: SpanContext{
+			TraceID: parent.TraceID,
+			SpanID:  randomHex(8),
+			Sampled: true,
+		},
+		name:  name,
+		kind:  kind,
+		start: time.Now(),
+		attrs: make(map[string]interface{}),
+	}
+
+	if parent.IsValid() {
+		s.parentID = parent.SpanID
+		s.sc.Sampled = parent.Sampled
+	} else {
+		s.sc.TraceID = randomHex(16)
+	}
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Returns nil if there's no span in ctx, which is safe to call methods on.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs[key] = value
+}
+
+// Marks the span as failed with err, if err isn't nil, and exports it. Only
+// the first call does anything.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+
+	end := time.Now()
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	if err != nil {
+		s.err = err.Error()
+	}
+
+	mu.RLock()
+	e := exporter
+	svc := service
+	mu.RUnlock()
+	if e == nil || !s.sc.Sampled {
+		s.mu.Unlock()
+		return
+	}
+
+	data := &SpanData{
+		Service:    svc,
+		TraceID:    s.sc.TraceID,
+		SpanID:     s.sc.SpanID,
+		ParentID:   s.parentID,
+		Name:       s.name,
+		Kind:       s.kind,
+		Start:      s.start,
+		End:        end,
+		Attributes: make(map[string]interface{}, len(s.attrs)),
+		Error:      s.err,
+	}
+	for k, v := range s.attrs {
+		data.Attributes[k] = v
+	}
+	s.mu.Unlock()
+
+	e.Export(data)
+}
+
+// Writes the span in ctx as a W3C traceparent header.
+func Inject(ctx context.Context, header http.Header) {
+	if sc := spanContextFrom(ctx); sc.IsValid() {
+		header.Set(HeaderTraceparent, FormatTraceparent(sc))
+	}
+}
+
+// Reads a W3C traceparent header.
+func Extract(header http.Header) (SpanContext, bool) {
+	return ParseTraceparent(header.Get(HeaderTraceparent))
+}
+
+func FormatTraceparent(sc SpanContext) string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// https://www.w3.org/TR/trace-context/#traceparent-header
+func ParseTraceparent(v string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, false
+	}
+	// Version 00 has exactly 4 parts, later versions may add more.
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+
+	traceID, spanID, flags := parts[1], parts[2], parts[3]
+	if !isHex(traceID, 32) || !isHex(spanID, 16) || !isHex(flags, 2) {
+		return SpanContext{}, false
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(spanID, "0") == "" {
+		return SpanContext{}, false
+	}
+
+	flagBits, _ := hex.DecodeString(flags)
+	return SpanContext{
+		TraceID: traceID,
+		SpanID:  spanID,
+		Sampled: flagBits[0]&1 == 1,
+	}, true
+}
+
+func spanContextFrom(ctx context.Context) SpanContext {
+	return FromContext(ctx).Context()
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		// Not worth failing a request over, the ID only needs to be unique.
+		return strings.Repeat("1", n*2)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,89 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		in   string
+		ok   bool
+		want SpanContext
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, SpanContext{"4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true}},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, SpanContext{"4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", false}},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, SpanContext{}},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, SpanContext{}},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, SpanContext{}},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, SpanContext{}},
+		{"", false, SpanContext{}},
+	}
+
+	for _, tc := range tests {
+		got, ok := ParseTraceparent(tc.in)
+		if ok != tc.ok || got != tc.want {
+			t.Errorf("%q: Got %+v, %v, want %+v, %v", tc.in, got, ok, tc.want, tc.ok)
+		}
+		if ok {
+			if got := FormatTraceparent(got); got != tc.in {
+				t.Errorf("Got %q, want %q", got, tc.in)
+			}
+		}
+	}
+}
+
+func TestSpanTree(t *testing.T) {
+	exporter := NewMemoryExporter()
+	SetExporter(exporter)
+	defer SetExporter(nil)
+
+	remote := SpanContext{"4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true}
+	ctx, root := StartWithKind(context.Background(), "GET /user/{user}", KindServer, remote)
+
+	childCtx, child := Start(ctx, "DBClient.GetUserDocs")
+	_, grandchild := Start(childCtx, "DBClient.getUser")
+	grandchild.End(nil)
+	child.End(errors.New("not found"))
+
+	_, sibling := Start(ctx, "DBClient.GetFollowingDocs")
+	sibling.End(nil)
+	root.End(nil)
+
+	want := "GET /user/{user}\n" +
+		"  DBClient.GetUserDocs\n" +
+		"    DBClient.getUser\n" +
+		"  DBClient.GetFollowingDocs\n"
+	if got := exporter.Tree(); got != want {
+		t.Errorf("Got:\n%s\nwant:\n%s", got, want)
+	}
+
+	for _, s := range exporter.Spans() {
+		if s.TraceID != remote.TraceID {
+			t.Errorf("%s: Got trace %s, want %s", s.Name, s.TraceID, remote.TraceID)
+		}
+	}
+
+	spans := exporter.Spans()
+	if got, want := spans[len(spans)-1].ParentID, remote.SpanID; got != want {
+		t.Errorf("Got root parent %s, want %s", got, want)
+	}
+	if got, want := spans[1].Error, "not found"; got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+}
+
+func TestEndOnce(t *testing.T) {
+	exporter := NewMemoryExporter()
+	SetExporter(exporter)
+	defer SetExporter(nil)
+
+	_, span := Start(context.Background(), "once")
+	span.End(nil)
+	span.End(errors.New("again"))
+
+	if got, want := len(exporter.Spans()), 1; got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+}
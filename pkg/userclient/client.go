@@ -0,0 +1,59 @@
+package userclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"holosam/appengine/demo/pkg/database"
+	"holosam/appengine/demo/pkg/util"
+)
+
+const (
+	TransportHTTP = "http"
+	TransportGRPC = "grpc"
+)
+
+// Client is how service-feed asks service-user to make changes.
+type Client interface {
+	Publish(ctx context.Context, pr *database.PublishRequest) (*database.PublishResponse, error)
+	Follow(ctx context.Context, fr *database.FollowRequest) (*database.FollowResponse, error)
+	// Checks that service-user is reachable and serving.
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+type Config struct {
+	Transport string `env:"USER_TRANSPORT" default:"http" oneof:"http|grpc"`
+	GRPCAddr  string `env:"USER_GRPC_ADDR"`
+	GRPCTLS   bool   `env:"USER_GRPC_TLS" default:"false"`
+	// Where the http transport finds service-user.
+	Endpoints util.EndpointsConfig
+}
+
+func (c *Config) Validate() error {
+	if c.Transport == TransportGRPC && c.GRPCAddr == "" {
+		return errors.New("USER_GRPC_ADDR is required when USER_TRANSPORT is grpc")
+	}
+	return nil
+}
+
+// Requests are signed with keys, over either transport.
+func New(project string, cfg Config, keys *util.ServiceKeys) (Client, error) {
+	switch cfg.Transport {
+	case TransportHTTP:
+		opts := make([]util.HttpClientOption, 0)
+		if keys != nil {
+			opts = append(opts, util.WithSigner(keys.Sign))
+		}
+		endpoints, err := util.NewEndpoints(project, cfg.Endpoints)
+		if err != nil {
+			return nil, err
+		}
+		return NewHTTP(util.NewHttpClient(opts...), endpoints), nil
+	case TransportGRPC:
+		return NewGRPC(cfg.GRPCAddr, cfg.GRPCTLS, keys)
+	default:
+		return nil, fmt.Errorf("unknown transport %q", cfg.Transport)
+	}
+}
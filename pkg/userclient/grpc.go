@@ -0,0 +1,154 @@
+package userclient
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"holosam/appengine/demo/pkg/database"
+	"holosam/appengine/demo/pkg/trace"
+	"holosam/appengine/demo/pkg/userpb"
+	"holosam/appengine/demo/pkg/util"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+type grpcClient struct {
+	conn   *grpc.ClientConn
+	client userpb.UserServiceClient
+	health healthpb.HealthClient
+}
+
+// App Engine standard only routes HTTP/1.1 traffic to instances, so addr has
+// to reach the user service's GRPC_PORT directly.
+// The caller's context deadline is sent along with every call, and with keys
+// every call is signed like the HTTP transport's requests.
+func NewGRPC(addr string, useTLS bool, keys *util.ServiceKeys) (Client, error) {
+	creds := insecure.NewCredentials()
+	if useTLS {
+		creds = credentials.NewTLS(&tls.Config{})
+	}
+
+	interceptors := []grpc.UnaryClientInterceptor{traceInterceptor}
+	if keys != nil {
+		interceptors = append(interceptors, signInterceptor(keys))
+	}
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(creds), grpc.WithChainUnaryInterceptor(interceptors...))
+	if err != nil {
+		return nil, fmt.Errorf("grpc dial %s error: %v", addr, err)
+	}
+
+	return &grpcClient{
+		conn:   conn,
+		client: userpb.NewUserServiceClient(conn),
+		health: healthpb.NewHealthClient(conn),
+	}, nil
+}
+
+func (c *grpcClient) Publish(ctx context.Context, pr *database.PublishRequest) (*database.PublishResponse, error) {
+	resp, err := c.client.Publish(ctx, &userpb.PublishRequest{
+		User: pr.User,
+		Text: pr.Text,
+	})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return &database.PublishResponse{ID: resp.GetDocId(), User: pr.User}, nil
+}
+
+func (c *grpcClient) Follow(ctx context.Context, fr *database.FollowRequest) (*database.FollowResponse, error) {
+	resp, err := c.client.Follow(ctx, &userpb.FollowRequest{
+		Src: fr.Src,
+		Dst: fr.Dst,
+	})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return &database.FollowResponse{
+		Src:       fr.Src,
+		Dst:       fr.Dst,
+		Following: int(resp.GetFollowing()),
+		Followers: int(resp.GetFollowers()),
+	}, nil
+}
+
+func (c *grpcClient) Ping(ctx context.Context) error {
+	resp, err := c.health.Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return fromStatus(err)
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("user service is %s", resp.GetStatus())
+	}
+	return nil
+}
+
+func (c *grpcClient) Close() error {
+	return c.conn.Close()
+}
+
+// Wraps each call in a client span, and sends the trace in the traceparent
+// metadata like the HTTP transport does in the header.
+func traceInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) (err error) {
+	ctx, span := trace.StartWithKind(ctx, "gRPC "+method, trace.KindClient, trace.SpanContext{})
+	defer func() { span.End(err) }()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, trace.HeaderTraceparent, trace.FormatTraceparent(span.Context()))
+	defer util.StartTiming(ctx, util.TimingGRPC)()
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// Signs the full method name and the request, which the server marshals the
+// same way to check it.
+func signInterceptor(keys *util.ServiceKeys) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if msg, ok := req.(proto.Message); ok {
+			body, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+			if err != nil {
+				return fmt.Errorf("sign %s error: %w", method, err)
+			}
+			if sig := keys.Signature(http.MethodPost, method, body); sig != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, util.HeaderServiceSignature, sig)
+			}
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// Turns status codes back into the util error kinds, so callers handle them
+// the same as errors from the HTTP transport.
+func fromStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	s := status.Convert(err)
+	var kind *util.Kind
+	switch s.Code() {
+	case codes.DeadlineExceeded:
+		return fmt.Errorf("user service call: %w", context.DeadlineExceeded)
+	case codes.Canceled:
+		return fmt.Errorf("user service call: %w", context.Canceled)
+	case codes.InvalidArgument:
+		kind = util.KindInvalid
+	case codes.NotFound:
+		kind = util.KindNotFound
+	case codes.Aborted, codes.AlreadyExists:
+		kind = util.KindConflict
+	case codes.Unauthenticated, codes.PermissionDenied:
+		kind = util.KindUnauthorized
+	case codes.Unavailable:
+		kind = util.KindUnavailable
+	default:
+		kind = util.KindInternal
+	}
+	return util.NewError(kind, s.Message(), fmt.Errorf("user service %s error: %s", s.Code(), s.Message()))
+}
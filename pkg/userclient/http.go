@@ -0,0 +1,58 @@
+package userclient
+
+import (
+	"context"
+
+	"holosam/appengine/demo/pkg/database"
+	"holosam/appengine/demo/pkg/util"
+)
+
+type httpClient struct {
+	client    *util.HttpClient
+	endpoints *util.Endpoints
+}
+
+func NewHTTP(client *util.HttpClient, endpoints *util.Endpoints) Client {
+	return &httpClient{
+		client:    client,
+		endpoints: endpoints,
+	}
+}
+
+func (c *httpClient) Publish(ctx context.Context, pr *database.PublishRequest) (*database.PublishResponse, error) {
+	resp, err := util.SendJSON[database.PublishResponse](ctx, c.client, util.ReqOpts{
+		Method:      "POST",
+		Url:         c.endpoints.URL(util.ServiceUser, "publish"),
+		JsonContent: pr,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *httpClient) Follow(ctx context.Context, fr *database.FollowRequest) (*database.FollowResponse, error) {
+	resp, err := util.SendJSON[database.FollowResponse](ctx, c.client, util.ReqOpts{
+		Method:      "POST",
+		Url:         c.endpoints.URL(util.ServiceUser, "follow"),
+		JsonContent: fr,
+		// Following someone twice is the same as once.
+		Idempotent: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *httpClient) Ping(ctx context.Context) error {
+	_, err := c.client.SendContext(ctx, util.ReqOpts{
+		Method: "GET",
+		Url:    c.endpoints.URL(util.ServiceUser, "healthz"),
+	})
+	return err
+}
+
+func (c *httpClient) Close() error {
+	return nil
+}
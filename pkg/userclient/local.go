@@ -0,0 +1,39 @@
+package userclient
+
+import (
+	"context"
+
+	"holosam/appengine/demo/pkg/database"
+)
+
+// What the local transport calls, which usersvc.Handler is.
+type Service interface {
+	Publish(ctx context.Context, pr *database.PublishRequest) (*database.PublishResponse, error)
+	Follow(ctx context.Context, fr *database.FollowRequest) (*database.FollowResponse, error)
+}
+
+type localClient struct {
+	svc Service
+}
+
+// Calls svc directly, for when both services run in one process. Errors come
+// back as svc returned them, with nothing lost to encoding.
+func NewLocal(svc Service) Client {
+	return &localClient{svc: svc}
+}
+
+func (c *localClient) Publish(ctx context.Context, pr *database.PublishRequest) (*database.PublishResponse, error) {
+	return c.svc.Publish(ctx, pr)
+}
+
+func (c *localClient) Follow(ctx context.Context, fr *database.FollowRequest) (*database.FollowResponse, error) {
+	return c.svc.Follow(ctx, fr)
+}
+
+func (c *localClient) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (c *localClient) Close() error {
+	return nil
+}
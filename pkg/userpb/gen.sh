@@ -0,0 +1,9 @@
+#!/bin/sh
+# Regenerates user.pb.go and user_grpc.pb.go, from the repo root:
+#   pkg/userpb/gen.sh
+# Needs protoc, protoc-gen-go (v1.27.1) and protoc-gen-go-grpc (v1.1.0).
+set -e
+protoc --proto_path=pkg/userpb \
+  --go_out=pkg/userpb --go_opt=paths=source_relative \
+  --go-grpc_out=pkg/userpb --go-grpc_opt=paths=source_relative \
+  user.proto
@@ -0,0 +1,141 @@
+package usersvc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"holosam/appengine/demo/pkg/database"
+	"holosam/appengine/demo/pkg/trace"
+	"holosam/appengine/demo/pkg/userpb"
+	"holosam/appengine/demo/pkg/util"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// A server with the user service, health checks and tracing, for the caller to
+// Serve and stop. With the handler's keys, calls need the same signature as
+// the HTTP API.
+func NewGRPCServer(h *Handler) *grpc.Server {
+	interceptors := []grpc.UnaryServerInterceptor{traceInterceptor}
+	if h.keys != nil {
+		interceptors = append(interceptors, signatureInterceptor(h.keys))
+	}
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
+	userpb.RegisterUserServiceServer(server, &grpcHandler{h: h})
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	return server
+}
+
+type grpcHandler struct {
+	userpb.UnimplementedUserServiceServer
+
+	h *Handler
+}
+
+func (g *grpcHandler) Publish(ctx context.Context, req *userpb.PublishRequest) (*userpb.PublishResponse, error) {
+	resp, err := g.h.Publish(ctx, &database.PublishRequest{
+		User: req.GetUser(),
+		Text: req.GetText(),
+	})
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	return &userpb.PublishResponse{DocId: resp.ID}, nil
+}
+
+func (g *grpcHandler) Follow(ctx context.Context, req *userpb.FollowRequest) (*userpb.FollowResponse, error) {
+	resp, err := g.h.Follow(ctx, &database.FollowRequest{
+		Src: req.GetSrc(),
+		Dst: req.GetDst(),
+	})
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	return &userpb.FollowResponse{
+		Following: int32(resp.Following),
+		Followers: int32(resp.Followers),
+	}, nil
+}
+
+// Continues the caller's trace from the traceparent metadata.
+func traceInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	var remote trace.SpanContext
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(trace.HeaderTraceparent); len(vals) > 0 {
+			remote, _ = trace.ParseTraceparent(vals[0])
+		}
+	}
+
+	ctx, span := trace.StartWithKind(ctx, "gRPC "+info.FullMethod, trace.KindServer, remote)
+	defer func() { span.End(err) }()
+	return handler(ctx, req)
+}
+
+func toStatus(ctx context.Context, err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+
+	code := codes.Internal
+	switch util.KindOf(err) {
+	case util.KindInvalid:
+		code = codes.InvalidArgument
+	case util.KindNotFound:
+		code = codes.NotFound
+	case util.KindConflict:
+		code = codes.Aborted
+	case util.KindUnauthorized:
+		code = codes.Unauthenticated
+	case util.KindUnavailable:
+		code = codes.Unavailable
+	}
+
+	if code == codes.Internal {
+		logger.Errorf(ctx, "gRPC internal error: %v", err)
+	} else {
+		logger.Infof(ctx, "gRPC error: %v", err)
+	}
+	return status.Error(code, util.PublicMessage(err))
+}
+
+// Checks the signature in the metadata, over the full method name and the
+// request, like userclient signs them. Health checks don't need one, the same
+// as /healthz.
+func signatureInterceptor(keys *util.ServiceKeys) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if strings.HasPrefix(info.FullMethod, "/grpc.health.v1.Health/") {
+			return handler(ctx, req)
+		}
+
+		msg, ok := req.(proto.Message)
+		if !ok {
+			return nil, status.Error(codes.Internal, "can't check the signature of a non-proto request")
+		}
+		body, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "can't check the request's signature")
+		}
+
+		var value string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if vals := md.Get(util.HeaderServiceSignature); len(vals) > 0 {
+				value = vals[0]
+			}
+		}
+		if err := keys.Check(value, http.MethodPost, info.FullMethod, body); err != nil {
+			logger.Infof(ctx, "gRPC %s: %v", info.FullMethod, err)
+			return nil, status.Error(codes.Unauthenticated, "missing or bad service signature")
+		}
+		return handler(ctx, req)
+	}
+}
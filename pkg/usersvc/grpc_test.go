@@ -0,0 +1,53 @@
+package usersvc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"holosam/appengine/demo/pkg/database"
+	"holosam/appengine/demo/pkg/userclient"
+	"holosam/appengine/demo/pkg/util"
+)
+
+func TestGRPCSignature(t *testing.T) {
+	ctx := context.Background()
+	store := database.NewMemoryStore()
+	if err := store.ModifyUser(ctx, "alice", func(u *database.User) {}, func() (database.User, error) { return database.NewUser("alice"), nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewGRPCServer(New(store, util.NewServiceKeys([]byte("key"))))
+	go server.Serve(lis)
+	defer server.Stop()
+
+	publish := func(keys *util.ServiceKeys) error {
+		client, err := userclient.NewGRPC(lis.Addr().String(), false, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		// Health checks don't need a signature.
+		if err := client.Ping(ctx); err != nil {
+			t.Errorf("Got %v pinging, want nil", err)
+		}
+		_, err = client.Publish(ctx, &database.PublishRequest{User: "alice", Text: "hi"})
+		return err
+	}
+
+	if err := publish(util.NewServiceKeys([]byte("key"))); err != nil {
+		t.Errorf("Got %v for a signed call, want nil", err)
+	}
+	if err := publish(nil); !errors.Is(err, util.KindUnauthorized) {
+		t.Errorf("Got %v for an unsigned call, want unauthorized", err)
+	}
+	if err := publish(util.NewServiceKeys([]byte("other key"))); !errors.Is(err, util.KindUnauthorized) {
+		t.Errorf("Got %v for the wrong key, want unauthorized", err)
+	}
+}
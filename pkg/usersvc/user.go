@@ -0,0 +1,115 @@
+package usersvc
+
+import (
+	"context"
+	"net/http"
+
+	"holosam/appengine/demo/pkg/database"
+	"holosam/appengine/demo/pkg/logging"
+	"holosam/appengine/demo/pkg/secrets"
+	"holosam/appengine/demo/pkg/util"
+)
+
+var logger = logging.New("user")
+
+// Everything service-user reads from the environment.
+type ServiceConfig struct {
+	Project string `env:"GOOGLE_CLOUD_PROJECT" required:"true"`
+	// gRPC is only served when a port is set, since App Engine standard can't
+	// route to it.
+	GRPCPort int `env:"GRPC_PORT" default:"0" min:"0" max:"65535"`
+
+	Server  util.ServerConfig
+	DB      database.Config
+	Secrets secrets.Config
+}
+
+type Handler struct {
+	db   database.Store
+	keys *util.ServiceKeys
+}
+
+// With keys, the HTTP API only takes requests signed by another service. nil
+// leaves it open, for when nothing calls it over HTTP.
+func New(db database.Store, keys *util.ServiceKeys) *Handler {
+	return &Handler{db: db, keys: keys}
+}
+
+func (h *Handler) Register(router *util.Router) {
+	if h.keys != nil {
+		router = router.Group("", h.keys.Verify)
+	}
+	router.HandleFunc(http.MethodPost, "/publish", h.publishHandler)
+	router.HandleFunc(http.MethodPost, "/follow", h.followHandler)
+}
+
+// Add a new record to the db, and notify all followers
+func (h *Handler) publishHandler(w http.ResponseWriter, r *http.Request) {
+	pr, err := util.DecodeJSON[database.PublishRequest](w, r)
+	if err != nil {
+		util.WriteJSONError(w, r, err)
+		return
+	}
+
+	resp, err := h.Publish(r.Context(), &pr)
+	if err != nil {
+		util.WriteJSONError(w, r, err)
+		return
+	}
+	util.WriteJSON(w, r, http.StatusOK, resp)
+}
+
+func (h *Handler) followHandler(w http.ResponseWriter, r *http.Request) {
+	fr, err := util.DecodeJSON[database.FollowRequest](w, r)
+	if err != nil {
+		util.WriteJSONError(w, r, err)
+		return
+	}
+
+	resp, err := h.Follow(r.Context(), &fr)
+	if err != nil {
+		util.WriteJSONError(w, r, err)
+		return
+	}
+	util.WriteJSON(w, r, http.StatusOK, resp)
+}
+
+// Shared by the HTTP and gRPC handlers, and called directly by in-process
+// clients.
+func (h *Handler) Publish(ctx context.Context, pr *database.PublishRequest) (*database.PublishResponse, error) {
+	if pr.User == "" {
+		return nil, util.NewError(util.KindInvalid, "missing user", nil)
+	}
+	util.SetRequestUser(ctx, pr.User)
+
+	doc, err := h.db.WriteDocument(ctx, pr)
+	if err != nil {
+		return nil, err
+	}
+	return &database.PublishResponse{ID: doc.ID, User: doc.Author}, nil
+}
+
+func (h *Handler) Follow(ctx context.Context, fr *database.FollowRequest) (*database.FollowResponse, error) {
+	if fr.Src == "" || fr.Dst == "" {
+		return nil, util.NewError(util.KindInvalid, "missing src and/or dst", nil)
+	}
+	util.SetRequestUser(ctx, fr.Src)
+
+	resp := &database.FollowResponse{Src: fr.Src, Dst: fr.Dst}
+	err := h.db.ModifyUser(ctx, fr.Src, func(u *database.User) {
+		u.AddFollowing(fr.Dst)
+		resp.Following = len(u.Following)
+	}, database.ErrNoUser)
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.db.ModifyUser(ctx, fr.Dst, func(u *database.User) {
+		u.AddFollower(fr.Src)
+		resp.Followers = len(u.Followers)
+	}, database.ErrNoUser)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,97 @@
+package util
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Guards the routes that are only for operators, like /metrics and
+// /debug/config, which need "Authorization: Bearer <token>". With no token
+// set they turn everyone away, so they're never public by accident. After a
+// rotation the previous token still works, so scrapers can catch up.
+//
+// With a pepper set, the token secret holds PepperToken's digest of the token
+// rather than the token itself, so ADMIN_TOKEN leaking on its own isn't
+// enough to get in.
+type AdminToken struct {
+	mu             sync.RWMutex
+	token          []byte
+	previous       []byte
+	pepper         []byte
+	previousPepper []byte
+}
+
+func NewAdminToken(token []byte) *AdminToken {
+	if len(token) == 0 {
+		logger.Warningf(context.Background(), "No %s set, the admin routes turn everyone away", EnvAdminToken)
+	}
+	return &AdminToken{token: token}
+}
+
+// An empty token is ignored.
+func (a *AdminToken) SetToken(token []byte) {
+	if len(token) == 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.previous, a.token = a.token, token
+}
+
+// An empty pepper is ignored. The previous pepper still works, so the
+// digests can be rotated after it.
+func (a *AdminToken) SetPepper(pepper []byte) {
+	if len(pepper) == 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.previousPepper, a.pepper = a.pepper, pepper
+}
+
+// What to store as the token secret for token, with TOKEN_PEPPER set to
+// pepper.
+func PepperToken(pepper []byte, token string) string {
+	mac := hmac.New(sha256.New, pepper)
+	mac.Write([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Middleware that turns away requests without the token, with a 401.
+func (a *AdminToken) Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, got, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		bearer := scheme == "Bearer"
+
+		a.mu.RLock()
+		tokens := [][]byte{a.token, a.previous}
+		peppers := [][]byte{a.pepper, a.previousPepper}
+		a.mu.RUnlock()
+
+		candidates := [][]byte{[]byte(got)}
+		if len(peppers[0]) > 0 {
+			candidates = candidates[:0]
+			for _, pepper := range peppers {
+				if len(pepper) > 0 {
+					candidates = append(candidates, []byte(PepperToken(pepper, got)))
+				}
+			}
+		}
+		for _, token := range tokens {
+			for _, candidate := range candidates {
+				if bearer && len(token) > 0 && subtle.ConstantTimeCompare(candidate, token) == 1 {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		WriteJSONError(w, r, NewError(KindUnauthorized, "admin token required", nil))
+	})
+}
@@ -0,0 +1,85 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	serve := func(a *AdminToken, auth string) int {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		a.Require(ok).ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	unset := NewAdminToken(nil)
+	for _, auth := range []string{"", "Bearer ", "Bearer x"} {
+		if got, want := serve(unset, auth), http.StatusUnauthorized; got != want {
+			t.Errorf("Got %v for %q with no token set, want %v", got, auth, want)
+		}
+	}
+
+	a := NewAdminToken([]byte("old"))
+	a.SetToken([]byte("new"))
+	cases := []struct {
+		auth string
+		want int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"new", http.StatusUnauthorized},
+		{"Bearer new", http.StatusOK},
+		{"Bearer old", http.StatusOK},
+	}
+	for _, c := range cases {
+		if got := serve(a, c.auth); got != c.want {
+			t.Errorf("Got %v for %q, want %v", got, c.auth, c.want)
+		}
+	}
+}
+
+func TestAdminTokenPepper(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	serve := func(a *AdminToken, token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		a.Require(ok).ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	a := NewAdminToken([]byte(PepperToken([]byte("pepper"), "secret")))
+	a.SetPepper([]byte("pepper"))
+	cases := []struct {
+		token string
+		want  int
+	}{
+		{"secret", http.StatusOK},
+		{PepperToken([]byte("pepper"), "secret"), http.StatusUnauthorized},
+		{"wrong", http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		if got := serve(a, c.token); got != c.want {
+			t.Errorf("Got %v for %q, want %v", got, c.token, c.want)
+		}
+	}
+
+	// A new pepper, then the digest made with it. Both steps keep the old
+	// token working.
+	a.SetPepper([]byte("pepper2"))
+	if got, want := serve(a, "secret"), http.StatusOK; got != want {
+		t.Errorf("Got %v after the pepper rotated, want %v", got, want)
+	}
+	a.SetToken([]byte(PepperToken([]byte("pepper2"), "secret2")))
+	for _, token := range []string{"secret", "secret2"} {
+		if got, want := serve(a, token), http.StatusOK; got != want {
+			t.Errorf("Got %v for %q after the token rotated, want %v", got, token, want)
+		}
+	}
+}
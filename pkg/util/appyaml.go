@@ -0,0 +1,115 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+
+	"holosam/appengine/demo/pkg/appyaml"
+	"holosam/appengine/demo/pkg/logging"
+)
+
+const SourceAppYAML = "app.yaml"
+
+var (
+	appYAMLMu   sync.Mutex
+	appYAMLKeys = make(map[string]bool)
+)
+
+// Sets the env_variables from an app.yaml that aren't already in the
+// environment, so a service runs locally like it's deployed. Keys that cfg's
+// tags don't use and that aren't secrets get a warning, since they're likely
+// typos.
+func ApplyAppYAML(path string, cfg interface{}) error {
+	f, err := appyaml.Load(path)
+	if err != nil {
+		return err
+	}
+	entries, err := f.EnvVariables()
+	if err != nil {
+		return err
+	}
+
+	appYAMLMu.Lock()
+	for _, e := range entries {
+		if _, ok := os.LookupEnv(e.Key); ok {
+			continue
+		}
+		if err := os.Setenv(e.Key, e.Value); err != nil {
+			appYAMLMu.Unlock()
+			return fmt.Errorf("set %s error: %w", e.Key, err)
+		}
+		appYAMLKeys[e.Key] = true
+	}
+	appYAMLMu.Unlock()
+
+	// LOG_LEVEL and friends might have just been set.
+	logging.Configure(logging.ConfigFromEnv())
+
+	known := EnvNames(cfg)
+	for _, e := range entries {
+		if !known[e.Key] {
+			logger.Warningf(context.Background(), "%s:%d: %s isn't read by this service", path, e.Line, e.Key)
+		}
+	}
+	return nil
+}
+
+// Every variable that a service with this config reads, from the env tags
+// plus the secrets, which pkg/secrets looks up by name.
+func EnvNames(cfg interface{}) map[string]bool {
+	names := make(map[string]bool)
+	for _, name := range SecretEnv {
+		names[name] = true
+	}
+	walkEnv(cfg, func(name string, field reflect.StructField) {
+		names[name] = true
+	})
+	return names
+}
+
+// The variables a service with this config reads that hold secrets, which
+// don't belong in app.yaml.
+func SecretNames(cfg interface{}) map[string]bool {
+	names := make(map[string]bool)
+	for _, name := range SecretEnv {
+		names[name] = true
+	}
+	walkEnv(cfg, func(name string, field reflect.StructField) {
+		if field.Tag.Get("secret") == "true" {
+			names[name] = true
+		}
+	})
+	return names
+}
+
+// Calls f for every field of cfg with an env tag, in nested structs too.
+func walkEnv(cfg interface{}, f func(name string, field reflect.StructField)) {
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if name, ok := field.Tag.Lookup("env"); ok {
+				f(name, field)
+			} else if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+				walk(field.Type)
+			}
+		}
+	}
+	if t := reflect.TypeOf(cfg); t != nil {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Struct {
+			walk(t)
+		}
+	}
+}
+
+func fromAppYAML(name string) bool {
+	appYAMLMu.Lock()
+	defer appYAMLMu.Unlock()
+	return appYAMLKeys[name]
+}
@@ -0,0 +1,37 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyAppYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.yaml")
+	err := os.WriteFile(path, []byte("env_variables:\n  TEST_APP_YAML_A: from-yaml\n  TEST_APP_YAML_B: from-yaml\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_APP_YAML_A", "from-env")
+	t.Setenv("TEST_APP_YAML_B", "")
+	os.Unsetenv("TEST_APP_YAML_B")
+
+	var cfg struct {
+		A string `env:"TEST_APP_YAML_A"`
+		B string `env:"TEST_APP_YAML_B"`
+	}
+	if err := ApplyAppYAML(path, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	report, err := LoadConfig(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.A != "from-env" || cfg.B != "from-yaml" {
+		t.Errorf("Got %q and %q, want from-env and from-yaml", cfg.A, cfg.B)
+	}
+	if got := report.Fields[1].Source; got != SourceAppYAML {
+		t.Errorf("Got source %v, want %v", got, SourceAppYAML)
+	}
+}
@@ -0,0 +1,163 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+var circuitState = DefaultRegistry.NewGaugeVec("http_client_circuit_state",
+	"Circuit breaker state by host: 0 closed, 1 half-open, 2 open.", "host")
+
+const (
+	circuitClosed = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+type BreakerConfig struct {
+	// Consecutive failures that open the circuit. Zero turns the breaker off.
+	FailureThreshold int
+	// How long the circuit stays open before letting probes through.
+	OpenTimeout time.Duration
+	// Requests allowed through at once while half-open. One success closes
+	// the circuit, one failure opens it again. Zero means one.
+	HalfOpenProbes int
+}
+
+var DefaultBreakerConfig = BreakerConfig{
+	FailureThreshold: 5,
+	OpenTimeout:      10 * time.Second,
+	HalfOpenProbes:   1,
+}
+
+// Keeps one circuit per host, so a failing service doesn't stop calls to the
+// others.
+type breakers struct {
+	config BreakerConfig
+
+	mu    sync.Mutex
+	hosts map[string]*circuit
+}
+
+type circuit struct {
+	state    int
+	failures int
+	openedAt time.Time
+	probes   int
+}
+
+func newBreakers(config BreakerConfig) *breakers {
+	// With no probes, an open circuit would never close.
+	if config.HalfOpenProbes <= 0 {
+		config.HalfOpenProbes = 1
+	}
+	return &breakers{
+		config: config,
+		hosts:  make(map[string]*circuit),
+	}
+}
+
+// How a request that allow let through went, for done.
+type breakerResult int
+
+const (
+	// The host answered, even if it was with a 4xx.
+	breakerSuccess breakerResult = iota
+	// Errors that say the host is unhealthy, like connection errors and 5xx
+	// responses.
+	breakerFailure
+	// Says nothing about the host, like a request the caller canceled.
+	breakerNeutral
+)
+
+// Returns ErrCircuitOpen if the request shouldn't be sent. Otherwise the
+// caller must report how it went with done, passing on probe, which says
+// whether the request was let through as a half-open probe.
+func (b *breakers) allow(host string) (probe bool, err error) {
+	if b.config.FailureThreshold <= 0 {
+		return false, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.get(host)
+
+	switch c.state {
+	case circuitOpen:
+		if time.Since(c.openedAt) < b.config.OpenTimeout {
+			return false, ErrCircuitOpen
+		}
+		b.setState(host, c, circuitHalfOpen)
+		fallthrough
+	case circuitHalfOpen:
+		if c.probes >= b.config.HalfOpenProbes {
+			return false, ErrCircuitOpen
+		}
+		c.probes++
+		return true, nil
+	}
+	return false, nil
+}
+
+func (b *breakers) done(host string, probe bool, result breakerResult) {
+	if b.config.FailureThreshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.get(host)
+
+	if probe {
+		c.probes--
+		// Another probe may have already closed or opened it.
+		if c.state == circuitHalfOpen {
+			switch result {
+			case breakerFailure:
+				c.openedAt = time.Now()
+				b.setState(host, c, circuitOpen)
+			case breakerSuccess:
+				c.failures = 0
+				b.setState(host, c, circuitClosed)
+			}
+			// A neutral probe frees its slot for the next one.
+			return
+		}
+	}
+
+	switch result {
+	case breakerSuccess:
+		c.failures = 0
+	case breakerFailure:
+		c.failures++
+		if c.state == circuitClosed && c.failures >= b.config.FailureThreshold {
+			c.openedAt = time.Now()
+			b.setState(host, c, circuitOpen)
+		}
+	}
+}
+
+// Must be called with b.mu held.
+func (b *breakers) get(host string) *circuit {
+	c, ok := b.hosts[host]
+	if !ok {
+		c = &circuit{}
+		b.hosts[host] = c
+	}
+	return c
+}
+
+// Must be called with b.mu held.
+func (b *breakers) setState(host string, c *circuit, state int) {
+	if c.state != state {
+		logger.Warningf(context.Background(), "Circuit for %s went from %s to %s", host, circuitNames[c.state], circuitNames[state])
+	}
+	c.state = state
+	circuitState.Set(float64(state), host)
+}
+
+var circuitNames = []string{"closed", "half-open", "open"}
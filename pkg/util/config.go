@@ -0,0 +1,284 @@
+package util
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config structs are loaded by LoadConfig from their field tags:
+//
+//	env:"NAME"       the variable to read. Untagged struct fields are loaded
+//	                 as nested configs, untagged anything else is skipped.
+//	default:"v"      used when NAME isn't set at all. Set but empty is a value.
+//	required:"true"  NAME must be set and not empty.
+//	min:"v" max:"v"  bounds for ints, floats and durations.
+//	oneof:"a|b"      allowed values for strings and each item of a list.
+//	secret:"true"    shown as [redacted] on /debug/config.
+//
+// Supported types are string, int, int64, float64, bool, time.Duration and
+// []string, which is comma separated. A config with a Validate() error method
+// has it called once its own fields loaded cleanly, for checks across fields.
+const (
+	SourceEnv     = "env"
+	SourceDefault = "default"
+	SourceUnset   = "unset"
+
+	redacted = "[redacted]"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Every problem found, so a bad deploy can be fixed in one go.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid config: " + strings.Join(e.Problems, "; ")
+}
+
+// The effective value of one variable and where it came from.
+type ConfigField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	Secret bool   `json:"secret,omitempty"`
+}
+
+type ConfigReport struct {
+	Fields []ConfigField `json:"fields"`
+}
+
+// Fills in the struct cfg points to from the environment. The report is
+// returned even with an error, since most of it is still useful.
+func LoadConfig(cfg interface{}) (*ConfigReport, error) {
+	return loadConfig(cfg, os.LookupEnv)
+}
+
+func loadConfig(cfg interface{}, lookup func(string) (string, bool)) (*ConfigReport, error) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config must be a pointer to a struct, not %T", cfg)
+	}
+
+	report := &ConfigReport{Fields: make([]ConfigField, 0)}
+	problems := loadStruct(v.Elem(), lookup, report)
+	if len(problems) > 0 {
+		return report, &ConfigError{Problems: problems}
+	}
+	return report, nil
+}
+
+func loadStruct(v reflect.Value, lookup func(string) (string, bool), report *ConfigReport) []string {
+	problems := make([]string, 0)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name, ok := field.Tag.Lookup("env")
+		if !ok {
+			if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+				problems = append(problems, loadStruct(v.Field(i), lookup, report)...)
+			}
+			continue
+		}
+
+		cf := ConfigField{Name: name, Secret: field.Tag.Get("secret") == "true"}
+		raw, set := lookup(name)
+		def, hasDefault := field.Tag.Lookup("default")
+		switch {
+		case set && fromAppYAML(name):
+			cf.Source = SourceAppYAML
+		case set:
+			cf.Source = SourceEnv
+		case hasDefault:
+			cf.Source = SourceDefault
+			raw = def
+		default:
+			cf.Source = SourceUnset
+		}
+
+		if field.Tag.Get("required") == "true" && raw == "" {
+			if set {
+				problems = append(problems, fmt.Sprintf("%s is set but empty", name))
+			} else {
+				problems = append(problems, fmt.Sprintf("%s is required", name))
+			}
+		} else if cf.Source != SourceUnset {
+			if err := setField(v.Field(i), field, raw); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+			}
+		}
+
+		cf.Value = formatField(v.Field(i))
+		if cf.Secret && cf.Value != "" {
+			cf.Value = redacted
+		}
+		report.Fields = append(report.Fields, cf)
+	}
+
+	if len(problems) == 0 {
+		if validator, ok := v.Addr().Interface().(interface{ Validate() error }); ok {
+			if err := validator.Validate(); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+	}
+	return problems
+}
+
+// Checks value the way LoadConfig would for the field of cfg tagged name, for
+// linting env vars before they're deployed. known is false if no field is.
+func CheckEnv(cfg interface{}, name, value string) (known bool, err error) {
+	t := reflect.TypeOf(cfg)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	field, ok := findEnvField(t, name)
+	if !ok {
+		return false, nil
+	}
+
+	if field.Tag.Get("required") == "true" && value == "" {
+		return true, fmt.Errorf("%s is set but empty", name)
+	}
+	return true, setField(reflect.New(field.Type).Elem(), field, value)
+}
+
+func findEnvField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if tag, ok := field.Tag.Lookup("env"); ok {
+			if tag == name {
+				return field, true
+			}
+		} else if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+			if f, ok := findEnvField(field.Type, name); ok {
+				return f, true
+			}
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func setField(v reflect.Value, field reflect.StructField, raw string) error {
+	tag := field.Tag
+	switch {
+	case field.Type == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%q isn't a duration", raw)
+		}
+		if err := checkBounds(tag, float64(d), func(s string) (float64, error) {
+			b, err := time.ParseDuration(s)
+			return float64(b), err
+		}, raw); err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+
+	case field.Type.Kind() == reflect.String:
+		if err := checkOneOf(tag, raw); err != nil {
+			return err
+		}
+		v.SetString(raw)
+
+	case field.Type.Kind() == reflect.Int, field.Type.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q isn't an integer", raw)
+		}
+		if err := checkBounds(tag, float64(n), parseFloat, raw); err != nil {
+			return err
+		}
+		v.SetInt(n)
+
+	case field.Type.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%q isn't a number", raw)
+		}
+		if err := checkBounds(tag, f, parseFloat, raw); err != nil {
+			return err
+		}
+		v.SetFloat(f)
+
+	case field.Type.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q isn't true or false", raw)
+		}
+		v.SetBool(b)
+
+	case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.String:
+		items := make([]string, 0)
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			if err := checkOneOf(tag, item); err != nil {
+				return err
+			}
+			items = append(items, item)
+		}
+		v.Set(reflect.ValueOf(items))
+
+	default:
+		return fmt.Errorf("unsupported config type %v", field.Type)
+	}
+	return nil
+}
+
+func checkBounds(tag reflect.StructTag, val float64, parse func(string) (float64, error), raw string) error {
+	if s, ok := tag.Lookup("min"); ok {
+		if min, err := parse(s); err == nil && val < min {
+			return fmt.Errorf("%s is less than the min of %s", raw, s)
+		}
+	}
+	if s, ok := tag.Lookup("max"); ok {
+		if max, err := parse(s); err == nil && val > max {
+			return fmt.Errorf("%s is more than the max of %s", raw, s)
+		}
+	}
+	return nil
+}
+
+func checkOneOf(tag reflect.StructTag, val string) error {
+	s, ok := tag.Lookup("oneof")
+	if !ok {
+		return nil
+	}
+	allowed := strings.Split(s, "|")
+	for _, a := range allowed {
+		if val == a {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q isn't one of %s", val, strings.Join(allowed, ", "))
+}
+
+func parseFloat(s string) (float64, error) {
+	return strconv.ParseFloat(s, 64)
+}
+
+func formatField(v reflect.Value) string {
+	if v.Kind() == reflect.Slice {
+		return strings.Join(v.Interface().([]string), ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// Serves the report as JSON, for /debug/config. Secrets are already redacted.
+func ConfigHandler(report *ConfigReport) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		WriteJSON(w, r, http.StatusOK, report)
+	}
+}
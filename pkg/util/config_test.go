@@ -0,0 +1,114 @@
+package util
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testNested struct {
+	Timeout time.Duration `env:"TIMEOUT" default:"5s" max:"1m"`
+}
+
+type testConfig struct {
+	Project string   `env:"PROJECT" required:"true"`
+	Threads int      `env:"THREADS" default:"10" min:"1" max:"100"`
+	Strat   string   `env:"STRAT" default:"none" oneof:"none|three"`
+	Hosts   []string `env:"HOSTS"`
+	Key     string   `env:"KEY" secret:"true"`
+	Nested  testNested
+}
+
+func (c *testConfig) Validate() error {
+	if c.Strat == "three" && c.Threads < 3 {
+		return errors.New("STRAT three needs 3 THREADS")
+	}
+	return nil
+}
+
+func lookupMap(env map[string]string) func(string) (string, bool) {
+	return func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	var cfg testConfig
+	report, err := loadConfig(&cfg, lookupMap(map[string]string{
+		"PROJECT": "demo",
+		"HOSTS":   "a, b,,",
+		"KEY":     "hunter2",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := testConfig{Project: "demo", Threads: 10, Strat: "none", Hosts: []string{"a", "b"}, Key: "hunter2", Nested: testNested{5 * time.Second}}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("Got %+v, want %+v", cfg, want)
+	}
+
+	wantFields := []ConfigField{
+		{Name: "PROJECT", Value: "demo", Source: SourceEnv},
+		{Name: "THREADS", Value: "10", Source: SourceDefault},
+		{Name: "STRAT", Value: "none", Source: SourceDefault},
+		{Name: "HOSTS", Value: "a,b", Source: SourceEnv},
+		{Name: "KEY", Value: redacted, Source: SourceEnv, Secret: true},
+		{Name: "TIMEOUT", Value: "5s", Source: SourceDefault},
+	}
+	if !reflect.DeepEqual(report.Fields, wantFields) {
+		t.Errorf("Got %+v, want %+v", report.Fields, wantFields)
+	}
+}
+
+func TestLoadConfigProblems(t *testing.T) {
+	var cfg testConfig
+	_, err := loadConfig(&cfg, lookupMap(map[string]string{
+		"PROJECT": "",
+		"THREADS": "500",
+		"STRAT":   "four",
+		"TIMEOUT": "soon",
+	}))
+
+	var ce *ConfigError
+	if !errors.As(err, &ce) {
+		t.Fatalf("Got %v, want a *ConfigError", err)
+	}
+	want := []string{
+		"PROJECT is set but empty",
+		"THREADS: 500 is more than the max of 100",
+		`STRAT: "four" isn't one of none, three`,
+		`TIMEOUT: "soon" isn't a duration`,
+	}
+	if !reflect.DeepEqual(ce.Problems, want) {
+		t.Errorf("Got %q, want %q", ce.Problems, want)
+	}
+
+	_, err = loadConfig(&cfg, lookupMap(map[string]string{"PROJECT": "demo", "STRAT": "three", "THREADS": "2"}))
+	if err == nil || err.Error() != "invalid config: STRAT three needs 3 THREADS" {
+		t.Errorf("Got %v, want the Validate error", err)
+	}
+}
+
+func TestLoggingConfig(t *testing.T) {
+	var cfg ServerConfig
+	if _, err := loadConfig(&cfg, lookupMap(map[string]string{"LOG_LEVEL": "warn", "LOG_LEVELS": "database=debug"})); err != nil {
+		t.Errorf("Got %v, want no error", err)
+	}
+
+	_, err := loadConfig(&cfg, lookupMap(map[string]string{"LOG_FORMAT": "yaml", "LOG_LEVELS": "database=loud"}))
+	var ce *ConfigError
+	if !errors.As(err, &ce) || len(ce.Problems) != 1 {
+		t.Fatalf("Got %v, want one problem", err)
+	}
+	want := `LOG_FORMAT: "yaml" isn't one of json, text; LOG_LEVELS: "loud" isn't a severity`
+	if ce.Problems[0] != want {
+		t.Errorf("Got %q, want %q", ce.Problems[0], want)
+	}
+
+	if names := EnvNames(&cfg); !names["LOG_LEVELS"] || !names[EnvFlashKey] || names[EnvLocalPorts] {
+		t.Errorf("Got %v, want the logging and secret variables but not %s", names, EnvLocalPorts)
+	}
+}
@@ -0,0 +1,111 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	ServiceFeed = "feed"
+	ServiceUser = "user"
+
+	// The App Engine region ID, the "uc" in uc.r.appspot.com.
+	EnvAppEngineRegion = "APPENGINE_REGION"
+	// Local ports by service, like "feed=8080,user=8081". Services in the map
+	// are called on http://localhost.
+	EnvLocalPorts = "LOCAL_PORTS"
+
+	appEngineURL        = "https://%s-dot-%s.%s.r.appspot.com"
+	appEngineVersionURL = "https://%s-dot-%s-dot-%s.%s.r.appspot.com"
+)
+
+// The variables Endpoints reads, see there.
+type EndpointsConfig struct {
+	Region      string   `env:"APPENGINE_REGION" default:"uc"`
+	LocalPorts  []string `env:"LOCAL_PORTS"`
+	FeedURL     string   `env:"FEED_SERVICE_URL"`
+	FeedVersion string   `env:"FEED_SERVICE_VERSION"`
+	UserURL     string   `env:"USER_SERVICE_URL"`
+	UserVersion string   `env:"USER_SERVICE_VERSION"`
+}
+
+// A bad LOCAL_PORTS entry is an error rather than skipped, since skipping it
+// would send that service's calls to App Engine instead.
+func (c *EndpointsConfig) Validate() error {
+	_, err := parseLocalPorts(c.LocalPorts)
+	return err
+}
+
+func (c *EndpointsConfig) service(name string) (url, version string) {
+	switch name {
+	case ServiceFeed:
+		return c.FeedURL, c.FeedVersion
+	case ServiceUser:
+		return c.UserURL, c.UserVersion
+	}
+	return "", ""
+}
+
+// Works out the base URL for each service. For a service named user, in order:
+//
+//  1. USER_SERVICE_URL, as is.
+//  2. The user entry in LOCAL_PORTS.
+//  3. App Engine's URL for the project and APPENGINE_REGION, with
+//     USER_SERVICE_VERSION in front if it's set, to target one version.
+type Endpoints struct {
+	project    string
+	cfg        EndpointsConfig
+	localPorts map[string]int
+}
+
+func NewEndpoints(project string, cfg EndpointsConfig) (*Endpoints, error) {
+	ports, err := parseLocalPorts(cfg.LocalPorts)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Region == "" {
+		cfg.Region = "uc"
+	}
+	return &Endpoints{project: project, cfg: cfg, localPorts: ports}, nil
+}
+
+func parseLocalPorts(entries []string) (map[string]int, error) {
+	ports := make(map[string]int)
+	bad := make([]string, 0)
+	for _, pair := range entries {
+		kv := strings.SplitN(pair, "=", 2)
+		port := 0
+		if len(kv) == 2 {
+			port, _ = strconv.Atoi(strings.TrimSpace(kv[1]))
+		}
+		if port <= 0 || port > 65535 {
+			bad = append(bad, fmt.Sprintf("%q", pair))
+			continue
+		}
+		ports[strings.TrimSpace(kv[0])] = port
+	}
+	if len(bad) > 0 {
+		return nil, fmt.Errorf("invalid %s entries %s, want service=port", EnvLocalPorts, strings.Join(bad, ", "))
+	}
+	return ports, nil
+}
+
+func (e *Endpoints) BaseURL(service string) string {
+	u, version := e.cfg.service(service)
+	if u != "" {
+		return strings.TrimSuffix(u, "/")
+	}
+	if port, ok := e.localPorts[service]; ok {
+		return fmt.Sprintf("http://localhost:%d", port)
+	}
+	if version != "" {
+		return fmt.Sprintf(appEngineVersionURL, version, service, e.project, e.cfg.Region)
+	}
+	return fmt.Sprintf(appEngineURL, service, e.project, e.cfg.Region)
+}
+
+// path is relative to the service root, like "publish".
+func (e *Endpoints) URL(service, path string) string {
+	return e.BaseURL(service) + "/" + strings.TrimPrefix(path, "/")
+}
@@ -0,0 +1,52 @@
+package util
+
+import "testing"
+
+func TestEndpoints(t *testing.T) {
+	cases := []struct {
+		cfg  EndpointsConfig
+		want string
+	}{
+		{EndpointsConfig{}, "https://user-dot-demo.uc.r.appspot.com/publish"},
+		{EndpointsConfig{Region: "ew", UserVersion: "canary"}, "https://canary-dot-user-dot-demo.ew.r.appspot.com/publish"},
+		{EndpointsConfig{LocalPorts: []string{"feed=8080", "user=8081"}}, "http://localhost:8081/publish"},
+		{EndpointsConfig{LocalPorts: []string{"user=8081"}, UserURL: "http://users.internal/"}, "http://users.internal/publish"},
+		{EndpointsConfig{FeedURL: "http://feed.internal", FeedVersion: "canary"}, "https://user-dot-demo.uc.r.appspot.com/publish"},
+	}
+
+	for _, c := range cases {
+		e, err := NewEndpoints("demo", c.cfg)
+		if err != nil {
+			t.Fatalf("NewEndpoints(%+v): %v", c.cfg, err)
+		}
+		if got := e.URL(ServiceUser, "/publish"); got != c.want {
+			t.Errorf("Got %v for %+v, want %v", got, c.cfg, c.want)
+		}
+	}
+}
+
+func TestEndpointsConfig(t *testing.T) {
+	var cfg EndpointsConfig
+	_, err := loadConfig(&cfg, lookupMap(map[string]string{
+		EnvLocalPorts:          "feed=8080, user=8081",
+		EnvAppEngineRegion:     "ew",
+		"FEED_SERVICE_VERSION": "canary",
+	}))
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	e, err := NewEndpoints("demo", cfg)
+	if err != nil {
+		t.Fatalf("NewEndpoints: %v", err)
+	}
+	if got, want := e.BaseURL(ServiceUser), "http://localhost:8081"; got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+
+	for _, ports := range []string{"user=nope", "user", "feed=8080,user=0", "user=70000"} {
+		var cfg EndpointsConfig
+		if _, err := loadConfig(&cfg, lookupMap(map[string]string{EnvLocalPorts: ports})); err == nil {
+			t.Errorf("Got no error for %s=%q, want one", EnvLocalPorts, ports)
+		}
+	}
+}
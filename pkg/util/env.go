@@ -1,11 +1,29 @@
 package util
 
 import (
-	"log"
+	"context"
 	"os"
 	"strconv"
 )
 
+// Most variables are read through config structs, see LoadConfig. These are
+// the ones that are also read or named elsewhere.
+const (
+	EnvCloudProject   = "GOOGLE_CLOUD_PROJECT"
+	EnvAppCredentials = "GOOGLE_APPLICATION_CREDENTIALS"
+
+	// Secrets, read through pkg/secrets, which is how they stay out of
+	// app.yaml.
+	EnvFlashKey    = "FLASH_KEY"
+	EnvServiceKey  = "SERVICE_HMAC_KEY"
+	EnvAdminToken  = "ADMIN_TOKEN"
+	EnvTokenPepper = "TOKEN_PEPPER"
+)
+
+var SecretEnv = []string{EnvFlashKey, EnvServiceKey, EnvAdminToken, EnvTokenPepper}
+
+// Deprecated: these are fields of the config structs now, like
+// feedsvc.Config. They'll be removed in a later release.
 const (
 	EnvHeadline         = "HEADLINE"
 	EnvTextColor        = "TEXT_COLOR"
@@ -15,46 +33,52 @@ const (
 	EnvFeedDocs         = "FEED_DOCS"
 	EnvIncludeFollowers = "INCLUDE_FOLLOWERS"
 	EnvTxnRetryStrat    = "TXN_RETRY_STRAT"
+)
 
-	EnvCloudProject   = "GOOGLE_CLOUD_PROJECT"
-	EnvAppCredentials = "GOOGLE_APPLICATION_CREDENTIALS"
-
+// Deprecated: use Endpoints, which also covers local runs, other regions
+// and versions.
+const (
 	FeedServiceURL = "https://feed-dot-%s.uc.r.appspot.com/%s"
 	UserServiceURL = "https://user-dot-%s.uc.r.appspot.com/%s"
 )
 
+// Deprecated: use LoadConfig, which also validates and reports every
+// problem at once. It'll be removed in a later release.
 func LoadEnvString(field, defaultVal string) string {
 	if v, ok := os.LookupEnv(field); ok {
 		return v
 	}
 
-	log.Printf("No %s field, defaulting to %s", field, defaultVal)
+	logger.Infof(context.Background(), "No %s field, defaulting to %s", field, defaultVal)
 	return defaultVal
 }
 
+// Deprecated: use LoadConfig.
 func LoadEnvInt(field string, defaultVal int) int {
 	if val, err := strconv.Atoi(LoadEnvString(field, strconv.Itoa(defaultVal))); err == nil {
 		return val
 	}
 
-	log.Printf("Invalid %s field, defaulting to %d", field, defaultVal)
+	logger.Warningf(context.Background(), "Invalid %s field, defaulting to %d", field, defaultVal)
 	return defaultVal
 }
 
+// Deprecated: use LoadConfig.
 func LoadEnvBool(field string, defaultVal bool) bool {
 	if val, err := strconv.ParseBool(LoadEnvString(field, strconv.FormatBool(defaultVal))); err == nil {
 		return val
 	}
 
-	log.Printf("Invalid %s field, defaulting to %v", field, defaultVal)
+	logger.Warningf(context.Background(), "Invalid %s field, defaulting to %v", field, defaultVal)
 	return defaultVal
 }
 
+// Deprecated: use LoadConfig with a required:"true" field.
 func MustLoadEnvString(field string) string {
 	if v := os.Getenv(field); v != "" {
 		return v
 	}
 
-	log.Fatalf("No %s field, exiting.", field)
+	logger.Fatalf(context.Background(), "No %s field, exiting.", field)
 	return ""
 }
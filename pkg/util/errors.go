@@ -0,0 +1,258 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// What went wrong, as far as the caller is concerned. Kinds are errors too, so
+// errors.Is(err, util.KindNotFound) works on anything made with NewError.
+type Kind struct {
+	code   string
+	status int
+}
+
+var (
+	KindNotFound         = &Kind{"not_found", http.StatusNotFound}
+	KindInvalid          = &Kind{"invalid_input", http.StatusBadRequest}
+	KindMethodNotAllowed = &Kind{"method_not_allowed", http.StatusMethodNotAllowed}
+	KindConflict         = &Kind{"conflict", http.StatusConflict}
+	KindUnauthorized     = &Kind{"unauthorized", http.StatusUnauthorized}
+	KindUnavailable      = &Kind{"unavailable", http.StatusServiceUnavailable}
+	KindInternal         = &Kind{"internal", http.StatusInternalServerError}
+
+	kinds = []*Kind{KindNotFound, KindInvalid, KindMethodNotAllowed, KindConflict, KindUnauthorized, KindUnavailable, KindInternal}
+)
+
+func (k *Kind) Error() string {
+	return strings.Replace(k.code, "_", " ", -1)
+}
+
+// Short and stable, for clients to switch on.
+func (k *Kind) Code() string {
+	return k.code
+}
+
+func (k *Kind) Status() int {
+	return k.status
+}
+
+// An error with a message that's safe to show users. Err has the full detail,
+// which is only logged.
+type Error struct {
+	Kind    *Kind
+	Message string
+	Err     error
+}
+
+func NewError(kind *Kind, message string, err error) *Error {
+	return &Error{Kind: kind, Message: message, Err: err}
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Message != "" && e.Err != nil:
+		return e.Message + ": " + e.Err.Error()
+	case e.Message != "":
+		return e.Message
+	case e.Err != nil:
+		return e.Err.Error()
+	default:
+		return e.Kind.Error()
+	}
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	k, ok := target.(*Kind)
+	return ok && k == e.Kind
+}
+
+// Anything that isn't an *Error, a Kind or a context error is internal.
+func KindOf(err error) *Kind {
+	if err == nil {
+		return nil
+	}
+
+	var e *Error
+	if errors.As(err, &e) && e.Kind != nil {
+		return e.Kind
+	}
+	var k *Kind
+	if errors.As(err, &k) {
+		return k
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return KindUnavailable
+	}
+	return KindInternal
+}
+
+// The outermost message meant for users, or a generic one for the kind.
+func PublicMessage(err error) string {
+	var e *Error
+	if errors.As(err, &e) && e.Message != "" {
+		return e.Message
+	}
+	return KindOf(err).Error()
+}
+
+func KindForCode(code string) (*Kind, bool) {
+	for _, k := range kinds {
+		if k.code == code {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+func KindForStatus(status int) *Kind {
+	switch {
+	case status == http.StatusNotFound:
+		return KindNotFound
+	case status == http.StatusUnauthorized, status == http.StatusForbidden:
+		return KindUnauthorized
+	case status == http.StatusMethodNotAllowed:
+		return KindMethodNotAllowed
+	case status == http.StatusConflict:
+		return KindConflict
+	case status == http.StatusBadGateway, status == http.StatusServiceUnavailable, status == http.StatusGatewayTimeout:
+		return KindUnavailable
+	case status >= 400 && status < 500:
+		return KindInvalid
+	default:
+		return KindInternal
+	}
+}
+
+// The same shape as service-feed's API envelope, with no data.
+type errorBody struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// What an error template is executed with.
+type ErrorPage struct {
+	Status    int
+	Code      string
+	Message   string
+	RequestID string
+}
+
+var defaultErrorPage = template.Must(template.New("error").Parse(`<!doctype html>
+<html lang="en">
+<head><meta charset="utf-8"><title>{{.Status}} {{.Message}}</title></head>
+<body>
+  <h1>{{.Message}}</h1>
+  {{if .RequestID}}<p>Request ID: {{.RequestID}}</p>{{end}}
+</body>
+</html>
+`))
+
+// Logs err in full and writes the status for its kind, with only the public
+// message in the body. The body is JSON if the client asked for it, otherwise
+// a plain error page.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	WriteErrorPage(w, r, err, nil)
+}
+
+// Like WriteError, but executes page with an ErrorPage for HTML responses. A
+// nil page uses the plain one.
+func WriteErrorPage(w http.ResponseWriter, r *http.Request, err error, page *template.Template) {
+	if page == nil {
+		page = defaultErrorPage
+	}
+	if wantsJSON(r) {
+		WriteJSONError(w, r, err)
+		return
+	}
+
+	kind := logError(r, err)
+	data := ErrorPage{
+		Status:    kind.status,
+		Code:      kind.code,
+		Message:   PublicMessage(err),
+		RequestID: GetRequestID(r.Context()),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(kind.status)
+	if err := page.Execute(w, data); err != nil {
+		logger.Errorf(r.Context(), "Error page render error: %v", err)
+	}
+}
+
+// Like WriteError, but always JSON.
+func WriteJSONError(w http.ResponseWriter, r *http.Request, err error) {
+	kind := logError(r, err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(kind.status)
+	err = json.NewEncoder(w).Encode(errorBody{errorDetail{
+		Status:  kind.status,
+		Code:    kind.code,
+		Message: PublicMessage(err),
+	}})
+	if err != nil {
+		logger.Errorf(r.Context(), "Error encode error: %v", err)
+	}
+}
+
+func logError(r *http.Request, err error) *Kind {
+	kind := KindOf(err)
+	if kind == nil {
+		kind = KindInternal
+		err = errors.New("WriteError called with a nil error")
+	}
+
+	if kind.status >= 500 {
+		logger.Errorf(r.Context(), "%s %s failed: %v", r.Method, r.URL.Path, err)
+	} else {
+		logger.Infof(r.Context(), "%s %s: %v", r.Method, r.URL.Path, err)
+	}
+	return kind
+}
+
+// Whichever of JSON and HTML comes first in Accept, defaulting to HTML.
+func wantsJSON(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		switch {
+		case mediaType == "application/json", strings.HasSuffix(mediaType, "+json"):
+			return true
+		case mediaType == "text/html":
+			return false
+		}
+	}
+	return false
+}
+
+// Turns an error response from another service back into an *Error, with the
+// kind from the JSON body if it has one and the status otherwise. The other
+// service's message is only kept in the detail, since what it says isn't up
+// to us.
+func errorFromResponse(status int, body []byte) error {
+	detail := fmt.Errorf("received HTTP status %d with body: %s", status, string(body))
+
+	kind := KindForStatus(status)
+	var eb errorBody
+	if err := json.Unmarshal(body, &eb); err == nil && eb.Error.Code != "" {
+		if k, ok := KindForCode(eb.Error.Code); ok {
+			kind = k
+		}
+	}
+	return NewError(kind, kind.Error(), detail)
+}
@@ -0,0 +1,98 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestKindOf(t *testing.T) {
+	notFound := NewError(KindNotFound, "user not found", errors.New("datastore: no such entity"))
+	cases := []struct {
+		err  error
+		want *Kind
+	}{
+		{notFound, KindNotFound},
+		{fmt.Errorf("wrapped: %w", notFound), KindNotFound},
+		{KindConflict, KindConflict},
+		{fmt.Errorf("call: %w", context.DeadlineExceeded), KindUnavailable},
+		{errors.New("something else"), KindInternal},
+		{nil, nil},
+	}
+
+	for _, c := range cases {
+		if got := KindOf(c.err); got != c.want {
+			t.Errorf("Got %v for %v, want %v", got, c.err, c.want)
+		}
+	}
+
+	if !errors.Is(fmt.Errorf("wrapped: %w", notFound), KindNotFound) {
+		t.Errorf("Got errors.Is false, want true")
+	}
+}
+
+func TestWriteErrorHidesDetail(t *testing.T) {
+	err := NewError(KindUnavailable, "try again later", errors.New("secret connection string"))
+
+	for _, accept := range []string{"text/html", "application/json"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		WriteError(rec, req, err)
+
+		if got, want := rec.Code, http.StatusServiceUnavailable; got != want {
+			t.Errorf("Got %v, want %v", got, want)
+		}
+		body := rec.Body.String()
+		if strings.Contains(body, "secret") || !strings.Contains(body, "try again later") {
+			t.Errorf("Got body %q for %s, want only the public message", body, accept)
+		}
+		if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, accept) {
+			t.Errorf("Got %v, want %v", got, accept)
+		}
+	}
+}
+
+func TestErrorFromResponse(t *testing.T) {
+	body, _ := json.Marshal(errorBody{errorDetail{Status: 404, Code: "not_found", Message: "user not found"}})
+	err := errorFromResponse(http.StatusNotFound, body)
+	if got, want := KindOf(err), KindNotFound; got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+	// The other service's message is only in the detail.
+	if got, want := PublicMessage(err), "not found"; got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+	if got := err.Error(); !strings.Contains(got, "user not found") {
+		t.Errorf("Got %q, want the detail to keep the message", got)
+	}
+
+	err = errorFromResponse(http.StatusBadGateway, []byte("<html>bad gateway</html>"))
+	if got, want := KindOf(err), KindUnavailable; got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+}
+
+func TestWriteErrorPage(t *testing.T) {
+	page := template.Must(template.New("error").Parse("custom: {{.Message}}"))
+	err := NewError(KindNotFound, "no such user", nil)
+
+	rec := httptest.NewRecorder()
+	WriteErrorPage(rec, httptest.NewRequest(http.MethodGet, "/", nil), err, page)
+	if got, want := rec.Body.String(), "custom: no such user"; got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+
+	// Other writers still get the plain page.
+	rec = httptest.NewRecorder()
+	WriteError(rec, httptest.NewRequest(http.MethodGet, "/", nil), err)
+	if got := rec.Body.String(); strings.HasPrefix(got, "custom") || !strings.Contains(got, "no such user") {
+		t.Errorf("Got %q, want the plain page", got)
+	}
+}
@@ -0,0 +1,154 @@
+package util
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Levels double as Bootstrap alert classes, like alert-danger.
+const (
+	FlashInfo    = "info"
+	FlashSuccess = "success"
+	FlashWarning = "warning"
+	FlashError   = "danger"
+)
+
+const (
+	flashCookie = "flash"
+	// Long enough to survive a redirect or two, short enough that a stale
+	// message doesn't show up much later.
+	flashMaxAge = 300
+	// Keeps the cookie well under the 4KB browsers allow.
+	maxFlashes = 5
+)
+
+// A one-time message for the next page the user sees.
+type Flash struct {
+	Level   string `json:"l"`
+	Message string `json:"m"`
+}
+
+// Keeps flash messages in an HMAC-signed cookie, so they belong to one browser
+// instead of the whole instance. Every instance needs the same key, or a
+// flash set on one is dropped by the others.
+type FlashStore struct {
+	mu  sync.RWMutex
+	key []byte
+	// Still accepted after a rotation, so messages signed just before it
+	// aren't dropped.
+	previous []byte
+}
+
+// An empty key gets a random one, which is only good for a single instance.
+func NewFlashStore(key []byte) *FlashStore {
+	if len(key) == 0 {
+		logger.Warningf(context.Background(), "No %s set, flash messages only work within one instance", EnvFlashKey)
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			logger.Fatalf(context.Background(), "Failed to generate flash key: %v", err)
+		}
+	}
+	return &FlashStore{key: key}
+}
+
+// Signs with key from now on. An empty key is ignored.
+func (f *FlashStore) SetKey(key []byte) {
+	if len(key) == 0 {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.previous, f.key = f.key, key
+}
+
+// Queues a message for the next page that calls Pop. Messages that haven't
+// been shown yet are kept, so a redirect chain doesn't lose them.
+func (f *FlashStore) Add(w http.ResponseWriter, r *http.Request, level, message string) {
+	flashes := append(f.read(r), Flash{Level: level, Message: message})
+	if len(flashes) > maxFlashes {
+		flashes = flashes[len(flashes)-maxFlashes:]
+	}
+
+	payload, err := json.Marshal(flashes)
+	if err != nil {
+		logger.Errorf(r.Context(), "Flash encode error: %v", err)
+		return
+	}
+	f.mu.RLock()
+	sig := signFlash(f.key, payload)
+	f.mu.RUnlock()
+	value := base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(sig)
+	http.SetCookie(w, f.cookie(r, value, flashMaxAge))
+}
+
+// Returns the queued messages and clears them, so they're shown once.
+func (f *FlashStore) Pop(w http.ResponseWriter, r *http.Request) []Flash {
+	if _, err := r.Cookie(flashCookie); err != nil {
+		return nil
+	}
+
+	http.SetCookie(w, f.cookie(r, "", -1))
+	return f.read(r)
+}
+
+// Anything missing, tampered with or signed with another key reads as no
+// messages.
+func (f *FlashStore) read(r *http.Request) []Flash {
+	c, err := r.Cookie(flashCookie)
+	if err != nil {
+		return nil
+	}
+
+	parts := strings.SplitN(c.Value, ".", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !f.verify(payload, sig) {
+		logger.Debugf(r.Context(), "Dropping flash cookie with a bad signature")
+		return nil
+	}
+
+	var flashes []Flash
+	if err := json.Unmarshal(payload, &flashes); err != nil {
+		return nil
+	}
+	return flashes
+}
+
+func (f *FlashStore) verify(payload, sig []byte) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return hmac.Equal(sig, signFlash(f.key, payload)) ||
+		(f.previous != nil && hmac.Equal(sig, signFlash(f.previous, payload)))
+}
+
+func signFlash(key, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(flashCookie + "\x00"))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func (f *FlashStore) cookie(r *http.Request, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     flashCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
@@ -0,0 +1,69 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// Carries the cookies set on one response over to the next request, like a
+// browser would.
+func nextRequest(rec *httptest.ResponseRecorder) *http.Request {
+	req := httptest.NewRequest("GET", "/", nil)
+	for _, c := range rec.Result().Cookies() {
+		if c.MaxAge >= 0 {
+			req.AddCookie(c)
+		}
+	}
+	return req
+}
+
+func TestFlashRoundTrip(t *testing.T) {
+	store := NewFlashStore([]byte("test key"))
+
+	rec := httptest.NewRecorder()
+	store.Add(rec, httptest.NewRequest("GET", "/", nil), FlashError, "first")
+	req := nextRequest(rec)
+
+	rec = httptest.NewRecorder()
+	store.Add(rec, req, FlashSuccess, "second")
+	req = nextRequest(rec)
+
+	rec = httptest.NewRecorder()
+	got := store.Pop(rec, req)
+	want := []Flash{{FlashError, "first"}, {FlashSuccess, "second"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+
+	if got := store.Pop(httptest.NewRecorder(), nextRequest(rec)); len(got) != 0 {
+		t.Errorf("Got %v after Pop, want nothing", got)
+	}
+}
+
+func TestFlashOtherKey(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewFlashStore([]byte("one key")).Add(rec, httptest.NewRequest("GET", "/", nil), FlashInfo, "hi")
+
+	if got := NewFlashStore([]byte("other key")).Pop(httptest.NewRecorder(), nextRequest(rec)); len(got) != 0 {
+		t.Errorf("Got %v, want nothing", got)
+	}
+}
+
+func TestFlashRotation(t *testing.T) {
+	store := NewFlashStore([]byte("old key"))
+	rec := httptest.NewRecorder()
+	store.Add(rec, httptest.NewRequest("GET", "/", nil), FlashInfo, "hi")
+
+	// Signed with the key before, so still good.
+	store.SetKey([]byte("new key"))
+	if got := store.Pop(httptest.NewRecorder(), nextRequest(rec)); len(got) != 1 {
+		t.Errorf("Got %v after one rotation, want the message", got)
+	}
+
+	store.SetKey([]byte("newer key"))
+	if got := store.Pop(httptest.NewRecorder(), nextRequest(rec)); len(got) != 0 {
+		t.Errorf("Got %v after two rotations, want nothing", got)
+	}
+}
@@ -0,0 +1,115 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const healthCheckTimeout = 5 * time.Second
+
+// HealthCheck returns an error if a dependency isn't usable.
+type HealthCheck func(ctx context.Context) error
+
+type healthStatus struct {
+	Status string                  `json:"status"`
+	Checks map[string]*checkStatus `json:"checks,omitempty"`
+}
+
+// Errors are only logged, since /readyz is public.
+type checkStatus struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+}
+
+// Liveness only says that the process is serving requests.
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, &healthStatus{Status: "ok"})
+}
+
+// Readiness runs all the checks in parallel, and is only OK if they all are.
+func ReadyzHandler(checks map[string]HealthCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		defer cancel()
+
+		resp := &healthStatus{
+			Status: "ok",
+			Checks: make(map[string]*checkStatus, len(checks)),
+		}
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for name, check := range checks {
+			wg.Add(1)
+			go func(name string, check HealthCheck) {
+				defer wg.Done()
+				start := time.Now()
+				err := check(ctx)
+
+				cs := &checkStatus{
+					Status:  "ok",
+					Latency: time.Since(start).String(),
+				}
+				if err != nil {
+					logger.Warningf(ctx, "Readiness check %s failed: %v", name, err)
+					cs.Status = "unavailable"
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				resp.Checks[name] = cs
+			}(name, check)
+		}
+		wg.Wait()
+
+		status := http.StatusOK
+		for _, cs := range resp.Checks {
+			if cs.Status != "ok" {
+				resp.Status = "unavailable"
+				status = http.StatusServiceUnavailable
+			}
+		}
+		writeHealth(w, status, resp)
+	}
+}
+
+// App Engine sends /_ah/warmup before routing traffic to a new instance, so
+// this is the place for slow one-time setup. The steps run in name order, and
+// a failed step doesn't stop the others.
+func WarmupHandler(steps map[string]HealthCheck) http.HandlerFunc {
+	names := make([]string, 0, len(steps))
+	for name := range steps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		failed := false
+		for _, name := range names {
+			start := time.Now()
+			if err := steps[name](r.Context()); err != nil {
+				logger.Errorf(r.Context(), "Warmup step %s failed: %v", name, err)
+				failed = true
+				continue
+			}
+			logger.Infof(r.Context(), "Warmup step %s took %v", name, time.Since(start))
+		}
+
+		if failed {
+			http.Error(w, "warmup failed", http.StatusInternalServerError)
+		}
+	}
+}
+
+func writeHealth(w http.ResponseWriter, status int, resp *healthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Errorf(context.Background(), "Health encode error: %v", err)
+	}
+}
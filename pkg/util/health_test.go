@@ -0,0 +1,33 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadyzHidesErrors(t *testing.T) {
+	h := ReadyzHandler(map[string]HealthCheck{
+		"db": func(ctx context.Context) error {
+			return errors.New("dial tcp 10.0.0.7:443: connection refused")
+		},
+		"users": func(ctx context.Context) error { return nil },
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if got, want := rec.Code, http.StatusServiceUnavailable; got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+	body := rec.Body.String()
+	if strings.Contains(body, "10.0.0.7") || strings.Contains(body, "refused") {
+		t.Errorf("Got %q, want no error detail", body)
+	}
+	if !strings.Contains(body, `"db":{"status":"unavailable"`) {
+		t.Errorf("Got %q, want db unavailable", body)
+	}
+}
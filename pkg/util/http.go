@@ -2,30 +2,116 @@ package util
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
+
+	"holosam/appengine/demo/pkg/logging"
+	"holosam/appengine/demo/pkg/trace"
 )
 
+var httpClientRetries = DefaultRegistry.NewCounterVec("http_client_retries_total",
+	"Outbound HTTP requests that were retried, by host.", "host")
+
+// Responses bigger than this are an error, so a bad upstream can't use up
+// the instance's memory.
+const DefaultMaxResponseBytes = 10 << 20
+
 type HttpClient struct {
-	client *http.Client
+	client           *http.Client
+	retry            RetryPolicy
+	breakers         *breakers
+	maxResponseBytes int64
+	sign             func(req *http.Request, body []byte)
 }
 
 type ReqOpts struct {
 	Method      string
 	Url         string
 	JsonContent interface{}
+	// Lets a request that isn't idempotent by method, like a POST that's safe
+	// to repeat, be retried.
+	Idempotent bool
+}
+
+type RetryPolicy struct {
+	// Including the first one, so 1 means no retries.
+	MaxAttempts int
+	// The wait before retry n is a random duration up to
+	// min(MaxDelay, BaseDelay * 2^n).
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    2 * time.Second,
+}
+
+type HttpClientOption func(*HttpClient)
+
+// Per attempt, so retries can take longer in total. Bound the whole call with
+// the context instead.
+func WithTimeout(d time.Duration) HttpClientOption {
+	return func(h *HttpClient) {
+		h.client.Timeout = d
+	}
+}
+
+func WithTransport(t http.RoundTripper) HttpClientOption {
+	return func(h *HttpClient) {
+		h.client.Transport = t
+	}
+}
+
+func WithRetryPolicy(p RetryPolicy) HttpClientOption {
+	return func(h *HttpClient) {
+		h.retry = p
+	}
+}
+
+func WithMaxResponseBytes(n int64) HttpClientOption {
+	return func(h *HttpClient) {
+		h.maxResponseBytes = n
+	}
+}
+
+// A zero FailureThreshold turns the breaker off.
+func WithBreaker(c BreakerConfig) HttpClientOption {
+	return func(h *HttpClient) {
+		h.breakers = newBreakers(c)
+	}
+}
+
+// Called on every attempt with the request and its body, like
+// ServiceKeys.Sign.
+func WithSigner(sign func(req *http.Request, body []byte)) HttpClientOption {
+	return func(h *HttpClient) {
+		h.sign = sign
+	}
 }
 
-// Could make client options tune-able.
-func NewHttpClient() *HttpClient {
-	return &HttpClient{
+type redirectTimingKey struct{}
+
+func NewHttpClient(opts ...HttpClientOption) *HttpClient {
+	h := &HttpClient{
 		client: &http.Client{
-			Timeout: 15 * time.Second,
+			Timeout:       15 * time.Second,
+			CheckRedirect: collectRedirectTiming,
 			Transport: &http.Transport{
 				Dial: (&net.Dialer{
 					Timeout: 10 * time.Second,
@@ -33,58 +119,299 @@ func NewHttpClient() *HttpClient {
 				IdleConnTimeout: 30 * time.Second,
 			},
 		},
+		retry:            DefaultRetryPolicy,
+		breakers:         newBreakers(DefaultBreakerConfig),
+		maxResponseBytes: DefaultMaxResponseBytes,
+	}
+	for _, opt := range opts {
+		opt(h)
 	}
+	return h
 }
 
 func (h *HttpClient) Send(reqOpts ReqOpts) ([]byte, error) {
+	return h.SendContext(context.Background(), reqOpts)
+}
+
+// Cancelling ctx cancels the request and any retries, and the trace in it is
+// propagated to the server.
+func (h *HttpClient) SendContext(ctx context.Context, reqOpts ReqOpts) ([]byte, error) {
+	body, _, err := h.SendWithHeader(ctx, reqOpts)
+	return body, err
+}
+
+// Like SendContext, but also returns the response header. Server-Timing
+// values from any redirects that were followed are added to it, so they
+// cover the whole chain.
+func (h *HttpClient) SendWithHeader(ctx context.Context, reqOpts ReqOpts) (_ []byte, _ http.Header, err error) {
 	if reqOpts.Method == "" || reqOpts.Url == "" {
-		return nil, fmt.Errorf("invalid request options: %+v", reqOpts)
+		return nil, nil, fmt.Errorf("invalid request options: %+v", reqOpts)
 	}
 
-	var reader io.Reader
+	ctx, span := trace.StartWithKind(ctx, "HTTP "+reqOpts.Method, trace.KindClient, trace.SpanContext{})
+	defer func() { span.End(err) }()
+	span.SetAttr("http.method", reqOpts.Method)
+
+	u, err := url.Parse(reqOpts.Url)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid url %q: %w", reqOpts.Url, err)
+	}
+	span.SetAttr("http.url", (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String())
+
+	var payload []byte
 	if reqOpts.JsonContent != nil {
-		payload, err := json.Marshal(reqOpts.JsonContent)
+		payload, err = json.Marshal(reqOpts.JsonContent)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+	}
 
-		reader = bytes.NewBuffer(payload)
-	} else {
-		reader = nil
+	attempts := 1
+	if reqOpts.Idempotent || isIdempotent(reqOpts.Method) {
+		attempts = h.retry.MaxAttempts
 	}
 
-	req, err := http.NewRequest(reqOpts.Method, reqOpts.Url, reader)
+	for i := 0; ; i++ {
+		span.SetAttr("attempts", i+1)
+		res, err := h.attempt(ctx, u.Host, reqOpts, payload)
+		if err == nil || i+1 >= attempts || !res.retry {
+			return res.body, res.header, err
+		}
+
+		wait := h.retry.backoff(i)
+		if res.retryAfter > wait {
+			wait = res.retryAfter
+		}
+		logger.Infof(ctx, "Retrying %s %s in %v after: %v", reqOpts.Method, reqOpts.Url, wait, err)
+		httpClientRetries.Inc(u.Host)
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, nil, fmt.Errorf("%v, and gave up retrying: %w", err, ctx.Err())
+		}
+	}
+}
+
+type attemptResult struct {
+	body   []byte
+	header http.Header
+	// Connection errors and 5xx responses are worth another try.
+	retry      bool
+	retryAfter time.Duration
+}
+
+// One try, reporting to the host's circuit breaker.
+func (h *HttpClient) attempt(ctx context.Context, host string, reqOpts ReqOpts, payload []byte) (attemptResult, error) {
+	probe, err := h.breakers.allow(host)
 	if err != nil {
-		return nil, err
+		// Not retried, since the circuit won't close within the backoff. The
+		// host is only in the detail, which isn't shown to users.
+		return attemptResult{}, NewError(KindUnavailable, "a service we depend on is unavailable", fmt.Errorf("%s: %w", host, err))
 	}
+	result := breakerFailure
+	defer func() { h.breakers.done(host, probe, result) }()
 
-	if reqOpts.JsonContent != nil {
+	var reader io.Reader
+	if payload != nil {
+		reader = bytes.NewReader(payload)
+	}
+
+	redirectTimings := make([]string, 0)
+	ctx = context.WithValue(ctx, redirectTimingKey{}, &redirectTimings)
+	req, err := http.NewRequestWithContext(ctx, reqOpts.Method, reqOpts.Url, reader)
+	if err != nil {
+		result = breakerNeutral
+		return attemptResult{}, err
+	}
+	trace.Inject(ctx, req.Header)
+	// So errors come back in a form errorFromResponse can decode.
+	req.Header.Set("Accept", "application/json")
+
+	if payload != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
+	if h.sign != nil {
+		h.sign(req, payload)
+	}
 
+	stopTiming := StartTiming(ctx, TimingHTTP)
+	defer stopTiming()
 	resp, err := h.client.Do(req)
 	if err != nil {
-		return nil, err
+		if ctx.Err() != nil {
+			// Our own cancellation says nothing about the host.
+			result = breakerNeutral
+			return attemptResult{}, ctx.Err()
+		}
+		return attemptResult{retry: true}, NewError(KindUnavailable, "couldn't reach a service we depend on", fmt.Errorf("%s: %w", host, err))
 	}
 
 	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	trace.FromContext(ctx).SetAttr("http.status_code", resp.StatusCode)
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, h.maxResponseBytes+1))
 	if err != nil {
-		return nil, err
+		if ctx.Err() != nil {
+			result = breakerNeutral
+			return attemptResult{}, ctx.Err()
+		}
+		return attemptResult{retry: true}, NewError(KindUnavailable, "a service we depend on sent a broken response", fmt.Errorf("%s: %w", host, err))
+	}
+	if int64(len(body)) > h.maxResponseBytes {
+		// The host answered, it's just more than we'll read, which doesn't
+		// make it unhealthy.
+		if resp.StatusCode < 500 {
+			result = breakerNeutral
+		}
+		return attemptResult{}, NewError(KindInternal, "a service we depend on sent a response that's too big",
+			fmt.Errorf("response to %s %s is over %d bytes", reqOpts.Method, reqOpts.Url, h.maxResponseBytes))
+	}
+
+	failed := resp.StatusCode >= 500
+	if !failed {
+		result = breakerSuccess
+	}
+	if resp.StatusCode/100 != 2 {
+		return attemptResult{
+			header:     resp.Header,
+			retry:      failed,
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}, errorFromResponse(resp.StatusCode, body)
+	}
+
+	for _, v := range redirectTimings {
+		resp.Header.Add(HeaderServerTiming, v)
+	}
+	return attemptResult{body: body, header: resp.Header}, nil
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	max := p.BaseDelay << uint(retry)
+	if max <= 0 || max > p.MaxDelay {
+		max = p.MaxDelay
+	}
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// Only the delay-seconds form, which is what App Engine and most proxies send.
+func parseRetryAfter(v string) time.Duration {
+	secs, err := strconv.Atoi(v)
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+func collectRedirectTiming(req *http.Request, via []*http.Request) error {
+	// Same limit as the default policy.
+	if len(via) >= 10 {
+		return fmt.Errorf("stopped after 10 redirects")
 	}
 
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("received HTTP status %d with body: %s", resp.StatusCode, string(body))
+	if timings, ok := req.Context().Value(redirectTimingKey{}).(*[]string); ok && req.Response != nil {
+		*timings = append(*timings, req.Response.Header.Values(HeaderServerTiming)...)
 	}
+	return nil
+}
 
-	return body, nil
+// What every service's main needs to serve.
+type ServerConfig struct {
+	Port            int           `env:"PORT" default:"8080" min:"1" max:"65535"`
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" default:"5s" min:"0s" max:"10m"`
+	Tracing         TracingConfig
+	Logging         LoggingConfig
 }
 
-func NewHttpServer(mux *http.ServeMux) *http.Server {
+// pkg/logging reads these itself, before any config loads, so loading can be
+// logged. They're here so a bad value fails startup like any other, and so
+// they show up in /debug/config.
+type LoggingConfig struct {
+	Format string   `env:"LOG_FORMAT"`
+	Level  string   `env:"LOG_LEVEL"`
+	Levels []string `env:"LOG_LEVELS"`
+}
+
+func (c *LoggingConfig) Validate() error {
+	problems := make([]string, 0)
+	if c.Format != "" && c.Format != logging.FormatJSON && c.Format != logging.FormatText {
+		problems = append(problems, fmt.Sprintf("%s: %q isn't one of %s, %s", logging.EnvLogFormat, c.Format, logging.FormatJSON, logging.FormatText))
+	}
+	if _, ok := logging.ParseSeverity(c.Level); c.Level != "" && !ok {
+		problems = append(problems, fmt.Sprintf("%s: %q isn't a severity", logging.EnvLogLevel, c.Level))
+	}
+	for _, pair := range c.Levels {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			problems = append(problems, fmt.Sprintf("%s: %q isn't name=severity", logging.EnvLogLevels, pair))
+		} else if _, ok := logging.ParseSeverity(kv[1]); !ok {
+			problems = append(problems, fmt.Sprintf("%s: %q isn't a severity", logging.EnvLogLevels, kv[1]))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Every request goes through RequestID, Tracing, RequestMetrics, AccessLog and
+// ServerTiming, and then any extra middleware in order. Recover wraps handler
+// inside the timeout, because TimeoutHandler runs handler in its own goroutine
+// and re-panics with a stack that doesn't show where the panic came from.
+func NewHttpServer(port int, handler http.Handler, middleware ...Middleware) *http.Server {
+	mw := append([]Middleware{countInFlight, RequestID, Tracing, RequestMetrics, AccessLog, ServerTiming}, middleware...)
+
 	return &http.Server{
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
-		Addr:         fmt.Sprintf(":%s", LoadEnvString("PORT", "8080")),
-		Handler:      http.TimeoutHandler(mux, 30*time.Second, "Timeout"),
+		Addr:         fmt.Sprintf(":%d", port),
+		Handler:      Chain(http.TimeoutHandler(Recover(handler), 30*time.Second, "Timeout"), mw...),
 	}
 }
+
+// Serves until SIGTERM or SIGINT, then stops accepting connections and waits
+// up to timeout for in-flight requests. The cleanup funcs run after that, in
+// order, with whatever is left of the timeout.
+func ListenAndServe(server *http.Server, timeout time.Duration, cleanup ...func(ctx context.Context) error) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server.ListenAndServe()
+	}()
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(sigc)
+
+	select {
+	case err := <-errc:
+		return err
+	case sig := <-sigc:
+		logger.Infof(context.Background(), "Received %v, shutting down with %d requests in flight", sig, InFlightRequests())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Warningf(ctx, "Shutdown cut off %d in-flight requests: %v", InFlightRequests(), err)
+	}
+
+	for _, f := range cleanup {
+		if err := f(ctx); err != nil {
+			logger.Errorf(ctx, "Shutdown cleanup error: %v", err)
+		}
+	}
+
+	logger.Infof(ctx, "Shutdown complete")
+	return nil
+}
@@ -0,0 +1,208 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var fastRetries = WithRetryPolicy(RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   time.Millisecond,
+	MaxDelay:    time.Millisecond,
+})
+
+// Fails with status the first failures times, then succeeds.
+func flakyServer(failures int32, status int) (*httptest.Server, *int32) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	return server, &calls
+}
+
+func TestRetriesIdempotent(t *testing.T) {
+	server, calls := flakyServer(2, http.StatusServiceUnavailable)
+	defer server.Close()
+
+	body, err := NewHttpClient(fastRetries).Send(ReqOpts{Method: "GET", Url: server.URL})
+	if err != nil || string(body) != "ok" {
+		t.Errorf("Got %q, %v, want ok", body, err)
+	}
+	if got, want := atomic.LoadInt32(calls), int32(3); got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+}
+
+func TestNoRetryForPostOr4xx(t *testing.T) {
+	server, calls := flakyServer(1, http.StatusServiceUnavailable)
+	defer server.Close()
+	client := NewHttpClient(fastRetries)
+
+	if _, err := client.Send(ReqOpts{Method: "POST", Url: server.URL}); KindOf(err) != KindUnavailable {
+		t.Errorf("Got %v, want unavailable", err)
+	}
+	if got, want := atomic.LoadInt32(calls), int32(1); got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+
+	server, calls = flakyServer(1, http.StatusNotFound)
+	defer server.Close()
+	if _, err := client.Send(ReqOpts{Method: "GET", Url: server.URL}); KindOf(err) != KindNotFound {
+		t.Errorf("Got %v, want not found", err)
+	}
+	if got, want := atomic.LoadInt32(calls), int32(1); got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	server, calls := flakyServer(2, http.StatusInternalServerError)
+	defer server.Close()
+	client := NewHttpClient(
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+		WithBreaker(BreakerConfig{FailureThreshold: 2, OpenTimeout: 20 * time.Millisecond, HalfOpenProbes: 1}),
+	)
+	get := func() error {
+		_, err := client.Send(ReqOpts{Method: "GET", Url: server.URL})
+		return err
+	}
+
+	get()
+	get()
+	if err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Got %v, want %v", err, ErrCircuitOpen)
+	}
+	if got, want := atomic.LoadInt32(calls), int32(2); got != want {
+		t.Errorf("Got %v calls, want %v", got, want)
+	}
+
+	// The probe after the timeout succeeds, which closes it again.
+	time.Sleep(30 * time.Millisecond)
+	if err := get(); err != nil {
+		t.Errorf("Got %v, want no error", err)
+	}
+	if err := get(); err != nil {
+		t.Errorf("Got %v, want no error", err)
+	}
+}
+
+func TestSendContextCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := NewHttpClient(fastRetries).SendContext(ctx, ReqOpts{Method: "GET", Url: server.URL})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestCircuitBreakerCanceledProbe(t *testing.T) {
+	server, calls := flakyServer(3, http.StatusInternalServerError)
+	defer server.Close()
+	client := NewHttpClient(
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+		WithBreaker(BreakerConfig{FailureThreshold: 2, OpenTimeout: 20 * time.Millisecond, HalfOpenProbes: 1}),
+	)
+	get := func(ctx context.Context) error {
+		_, err := client.SendContext(ctx, ReqOpts{Method: "GET", Url: server.URL})
+		return err
+	}
+
+	get(context.Background())
+	get(context.Background())
+	time.Sleep(30 * time.Millisecond)
+
+	// A probe the caller cancels doesn't close the circuit, so the next probe
+	// still decides, and its failure opens it again.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := get(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Got %v, want %v", err, context.Canceled)
+	}
+	get(context.Background())
+	if err := get(context.Background()); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Got %v, want %v", err, ErrCircuitOpen)
+	}
+	if got, want := atomic.LoadInt32(calls), int32(3); got != want {
+		t.Errorf("Got %v calls, want %v", got, want)
+	}
+}
+
+func TestBreakerOnlyProbesFreeProbes(t *testing.T) {
+	b := newBreakers(BreakerConfig{FailureThreshold: 1, OpenTimeout: 10 * time.Millisecond, HalfOpenProbes: 1})
+
+	// Let through while closed, and still going when the circuit opens.
+	slow, _ := b.allow("host")
+	probe, _ := b.allow("host")
+	b.done("host", probe, breakerFailure)
+	time.Sleep(20 * time.Millisecond)
+
+	probe, err := b.allow("host")
+	if !probe || err != nil {
+		t.Fatalf("Got %v, %v, want a probe", probe, err)
+	}
+	b.done("host", slow, breakerSuccess)
+	if _, err := b.allow("host"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Got %v, want %v while the probe is out", err, ErrCircuitOpen)
+	}
+
+	b.done("host", probe, breakerSuccess)
+	if _, err := b.allow("host"); err != nil {
+		t.Errorf("Got %v, want no error", err)
+	}
+}
+
+func TestBreakerZeroProbes(t *testing.T) {
+	b := newBreakers(BreakerConfig{FailureThreshold: 1, OpenTimeout: 10 * time.Millisecond})
+
+	probe, _ := b.allow("host")
+	b.done("host", probe, breakerFailure)
+	time.Sleep(20 * time.Millisecond)
+
+	if probe, err := b.allow("host"); !probe || err != nil {
+		t.Errorf("Got %v, %v, want a probe", probe, err)
+	}
+}
+
+func TestOversizedResponseKeepsCircuit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 100)))
+	}))
+	defer server.Close()
+
+	client := NewHttpClient(fastRetries, WithMaxResponseBytes(10),
+		WithBreaker(BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute}))
+	for i := 0; i < 2; i++ {
+		if _, err := client.Send(ReqOpts{Method: "GET", Url: server.URL}); KindOf(err) != KindInternal || errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("Got %v, want a too big error", err)
+		}
+	}
+}
+
+func TestErrorsHideHost(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	host := server.Listener.Addr().String()
+	server.Close()
+
+	_, err := NewHttpClient(fastRetries).Send(ReqOpts{Method: "GET", Url: server.URL})
+	if got := PublicMessage(err); strings.Contains(got, host) {
+		t.Errorf("Got %q, want no host", got)
+	}
+	if !strings.Contains(err.Error(), host) {
+		t.Errorf("Got %q, want the host in the detail", err)
+	}
+}
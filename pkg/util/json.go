@@ -0,0 +1,61 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// The most DecodeJSON reads from a request body.
+const MaxRequestBodyBytes = 1 << 20
+
+// Sends reqOpts, with JsonContent as the body if it's set, and decodes the
+// response into a Resp. Error responses come back as *Error, like with Send.
+func SendJSON[Resp any](ctx context.Context, h *HttpClient, reqOpts ReqOpts) (Resp, error) {
+	var resp Resp
+	body, header, err := h.SendWithHeader(ctx, reqOpts)
+	if err != nil {
+		return resp, err
+	}
+
+	if !isJSON(header.Get("Content-Type")) {
+		return resp, NewError(KindInternal, "unexpected response from another service",
+			fmt.Errorf("%s %s returned Content-Type %q, want JSON", reqOpts.Method, reqOpts.Url, header.Get("Content-Type")))
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return resp, NewError(KindInternal, "unexpected response from another service",
+			fmt.Errorf("%s %s returned invalid JSON: %w", reqOpts.Method, reqOpts.Url, err))
+	}
+	return resp, nil
+}
+
+// Reads a JSON request body of at most MaxRequestBodyBytes into a T. Unknown
+// fields are ignored, so a caller can be deployed with new fields first.
+func DecodeJSON[T any](w http.ResponseWriter, r *http.Request) (T, error) {
+	var v T
+	defer r.Body.Close()
+
+	if ct := r.Header.Get("Content-Type"); ct != "" && !isJSON(ct) {
+		return v, NewError(KindInvalid, fmt.Sprintf("Content-Type %q isn't JSON", ct), nil)
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxRequestBodyBytes)).Decode(&v); err != nil {
+		return v, NewError(KindInvalid, "invalid JSON body", err)
+	}
+	return v, nil
+}
+
+func WriteJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Errorf(r.Context(), "JSON encode error: %v", err)
+	}
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
@@ -0,0 +1,77 @@
+package util
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type echoReq struct {
+	Name string `json:"name"`
+}
+
+type echoResp struct {
+	Greeting string `json:"greeting"`
+}
+
+func TestSendJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := DecodeJSON[echoReq](w, r)
+		if err != nil {
+			WriteJSONError(w, r, err)
+			return
+		}
+		WriteJSON(w, r, http.StatusOK, echoResp{Greeting: "hi " + req.Name})
+	}))
+	defer server.Close()
+	client := NewHttpClient()
+
+	got, err := SendJSON[echoResp](context.Background(), client, ReqOpts{
+		Method:      "POST",
+		Url:         server.URL,
+		JsonContent: echoReq{Name: "bob"},
+	})
+	if err != nil || got.Greeting != "hi bob" {
+		t.Errorf("Got %+v, %v, want hi bob", got, err)
+	}
+
+	// No body decodes to an error from the server.
+	_, err = SendJSON[echoResp](context.Background(), client, ReqOpts{Method: "POST", Url: server.URL})
+	if got, want := KindOf(err), KindInvalid; got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+}
+
+func TestSendJSONChecksResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html></html>"))
+	}))
+	defer server.Close()
+
+	_, err := SendJSON[echoResp](context.Background(), NewHttpClient(), ReqOpts{Method: "GET", Url: server.URL})
+	if got, want := KindOf(err), KindInternal; got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+
+	big := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"greeting":"` + strings.Repeat("a", 100) + `"}`))
+	}))
+	defer big.Close()
+
+	_, err = SendJSON[echoResp](context.Background(), NewHttpClient(WithMaxResponseBytes(50)), ReqOpts{Method: "GET", Url: big.URL})
+	if err == nil || !strings.Contains(err.Error(), "too big") {
+		t.Errorf("Got %v, want a too big error", err)
+	}
+}
+
+func TestDecodeJSONContentType(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"name":"bob"}`))
+	req.Header.Set("Content-Type", "text/plain")
+	if _, err := DecodeJSON[echoReq](httptest.NewRecorder(), req); KindOf(err) != KindInvalid {
+		t.Errorf("Got %v, want invalid input", err)
+	}
+}
@@ -0,0 +1,64 @@
+package util
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsText(t *testing.T) {
+	reg := NewRegistry()
+	counter := reg.NewCounterVec("test_requests_total", "Requests.", "route")
+	gauge := reg.NewGaugeVec("test_in_use", "In use.")
+	hist := reg.NewHistogramVec("test_latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+
+	counter.Inc("/user/{user}")
+	counter.Add(2, "/user/{user}")
+	counter.Inc(`/a"b`)
+	gauge.Add(3)
+	gauge.Add(-1)
+	hist.Observe(0.05, "/")
+	hist.Observe(0.5, "/")
+	hist.Observe(5, "/")
+
+	var buf bytes.Buffer
+	reg.WriteText(&buf)
+
+	want := strings.Join([]string{
+		"# HELP test_in_use In use.",
+		"# TYPE test_in_use gauge",
+		"test_in_use 2",
+		"# HELP test_latency_seconds Latency.",
+		"# TYPE test_latency_seconds histogram",
+		`test_latency_seconds_bucket{route="/",le="0.1"} 1`,
+		`test_latency_seconds_bucket{route="/",le="1"} 2`,
+		`test_latency_seconds_bucket{route="/",le="+Inf"} 3`,
+		`test_latency_seconds_sum{route="/"} 5.55`,
+		`test_latency_seconds_count{route="/"} 3`,
+		"# HELP test_requests_total Requests.",
+		"# TYPE test_requests_total counter",
+		`test_requests_total{route="/a\"b"} 1`,
+		`test_requests_total{route="/user/{user}"} 3`,
+		"",
+	}, "\n")
+
+	if got := buf.String(); got != want {
+		t.Errorf("Got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRequestMetricsMethod(t *testing.T) {
+	h := RequestMetrics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, method := range []string{"BREW", http.MethodGet} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/", nil))
+	}
+
+	var buf bytes.Buffer
+	DefaultRegistry.WriteText(&buf)
+	got := buf.String()
+	if strings.Contains(got, "BREW") || !strings.Contains(got, `method="other"`) || !strings.Contains(got, `method="GET"`) {
+		t.Errorf("Got:\n%s\nwant BREW counted as other", got)
+	}
+}
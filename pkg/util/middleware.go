@@ -0,0 +1,194 @@
+package util
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"holosam/appengine/demo/pkg/logging"
+)
+
+const HeaderRequestID = "X-Request-ID"
+
+var logger = logging.New("util")
+
+// Requests that have started and not finished, across all servers in the
+// process.
+var inFlightRequests int64
+
+type requestInfoKey struct{}
+
+// Request-scoped values that handlers fill in for the middleware to use. The
+// ID and user are kept by the logging package, so they're on every log line.
+type requestInfo struct {
+	mu    sync.Mutex
+	route string
+}
+
+// Applies the middleware in order, so the first one is the outermost.
+func Chain(handler http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// Uses the caller's X-Request-ID if there is one, otherwise generates it.
+// Either way it's echoed back in the response, and logged with everything
+// the request logs.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(HeaderRequestID)
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+		}
+
+		w.Header().Set(HeaderRequestID, id)
+		ctx := logging.WithRequest(r.Context(), id, r.Header)
+		ctx = context.WithValue(ctx, requestInfoKey{}, &requestInfo{})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Turns a panic into a 500 instead of dropping the connection, and logs the
+// stack. If the handler already started its response, a 500 would be
+// appended to a partial page, so the response is aborted instead.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				// The server handles this one quietly on purpose.
+				panic(rec)
+			}
+
+			logger.Errorf(r.Context(), "Panic serving %s %s: %v\n%s", r.Method, r.URL.Path, rec, debug.Stack())
+			if sw.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(sw, r)
+	})
+}
+
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		severity := logging.Info
+		if sw.Status() >= 500 {
+			severity = logging.Error
+		}
+		// Only the path, since the query string carries what users publish.
+		logger.Log(r.Context(), severity, "%s %s %d %dB %v", r.Method, r.URL.Path, sw.Status(), sw.bytes, time.Since(start))
+	})
+}
+
+func countInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&inFlightRequests, 1)
+		defer atomic.AddInt64(&inFlightRequests, -1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func InFlightRequests() int {
+	return int(atomic.LoadInt64(&inFlightRequests))
+}
+
+func GetRequestID(ctx context.Context) string {
+	return logging.RequestID(ctx)
+}
+
+// Lets a handler say which user the request is for, so it can be logged.
+func SetRequestUser(ctx context.Context, user string) {
+	logging.SetUser(ctx, user)
+}
+
+func GetRequestUser(ctx context.Context) string {
+	return logging.User(ctx)
+}
+
+// Set by the router, since middleware outside of it can't see the context
+// that it adds.
+func setRequestRoute(ctx context.Context, route string) {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		info.mu.Lock()
+		info.route = route
+		info.mu.Unlock()
+	}
+}
+
+func getRequestRoute(ctx context.Context) string {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		info.mu.Lock()
+		defer info.mu.Unlock()
+		return info.route
+	}
+	return ""
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// Keeps track of what was written, for logging.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusWriter) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusWriter) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+// Streaming handlers still need to flush through the wrapper.
+func (s *statusWriter) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		if s.status == 0 {
+			s.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+func (s *statusWriter) Status() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
+
+func (s *statusWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -0,0 +1,135 @@
+package util
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"holosam/appengine/demo/pkg/logging"
+)
+
+func TestRequestIDPropagated(t *testing.T) {
+	var got string
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = GetRequestID(r.Context())
+	}), RequestID)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(HeaderRequestID, "abc123")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if want := "abc123"; got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+	if got, want := rec.Header().Get(HeaderRequestID), "abc123"; got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), RequestID)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := rec.Header().Get(HeaderRequestID); len(got) != 32 {
+		t.Errorf("Got %q, want a 32 char ID", got)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	var user string
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetRequestUser(r.Context(), "bob")
+		panic("oops")
+	}), RequestID, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			user = GetRequestUser(r.Context())
+		})
+	}, Recover)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got, want := rec.Code, http.StatusInternalServerError; got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+	if want := "bob"; user != want {
+		t.Errorf("Got %q, want %q", user, want)
+	}
+}
+
+func TestRecoverAfterWrite(t *testing.T) {
+	h := Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial page"))
+		panic("oops")
+	}))
+
+	rec := httptest.NewRecorder()
+	func() {
+		defer func() {
+			if got, want := recover(), http.ErrAbortHandler; got != want {
+				t.Errorf("Got %v, want %v", got, want)
+			}
+		}()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+
+	if got, want := rec.Body.String(), "partial page"; got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+}
+
+func TestStatusWriterFlush(t *testing.T) {
+	rec := httptest.NewRecorder()
+	var w http.ResponseWriter = &statusWriter{ResponseWriter: rec}
+
+	f, ok := w.(http.Flusher)
+	if !ok {
+		t.Fatalf("Got %T, want an http.Flusher", w)
+	}
+	f.Flush()
+	if !rec.Flushed {
+		t.Errorf("Got %v, want flushed", rec.Flushed)
+	}
+}
+
+func TestAccessLogHidesQuery(t *testing.T) {
+	var buf bytes.Buffer
+	logging.SetOutput(&buf)
+	defer logging.SetOutput(os.Stdout)
+
+	h := AccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/publish?user=bob&text=secret", nil))
+
+	if got := buf.String(); !strings.Contains(got, "GET /publish 200") || strings.Contains(got, "secret") {
+		t.Errorf("Got %q, want the path without the query", got)
+	}
+}
+
+func panickyHandler(w http.ResponseWriter, r *http.Request) {
+	panic("oops")
+}
+
+func TestRecoverInServer(t *testing.T) {
+	var buf bytes.Buffer
+	logging.SetOutput(&buf)
+	defer logging.SetOutput(os.Stdout)
+
+	server := NewHttpServer(0, http.HandlerFunc(panickyHandler))
+	rec := httptest.NewRecorder()
+	server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got, want := rec.Code, http.StatusInternalServerError; got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+	// The stack is the handler's, not TimeoutHandler's.
+	if got := buf.String(); !strings.Contains(got, "util.panickyHandler") {
+		t.Errorf("Got %q, want the stack to name panickyHandler", got)
+	}
+}
@@ -0,0 +1,290 @@
+package util
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type Middleware func(http.Handler) http.Handler
+
+// Routes registered with MethodAny match every method, like http.ServeMux.
+// A route for the same pattern and the request's method takes priority.
+const MethodAny = "*"
+
+// Router matches on method and path, where a path segment like {user} is a
+// parameter that handlers read with PathParam(). A last segment like
+// {rest...} matches the rest of the path, even if it's empty. A static segment
+// takes priority over a parameter, and a parameter over the rest, in the same
+// position.
+// Groups share the routes of the router they came from, but add a path prefix
+// and their own middleware.
+type Router struct {
+	table      *routeTable
+	prefix     string
+	middleware []Middleware
+}
+
+type routeTable struct {
+	routes []*route
+	// Keyed by group prefix, the longest matching prefix is used.
+	notFound         map[string]http.Handler
+	methodNotAllowed map[string]http.Handler
+}
+
+type route struct {
+	method   string
+	pattern  string
+	segments []string
+	handler  http.Handler
+}
+
+type routeMatch struct {
+	pattern string
+	params  map[string]string
+}
+
+type routeKey struct{}
+
+func NewRouter() *Router {
+	return &Router{
+		table: &routeTable{
+			notFound:         make(map[string]http.Handler),
+			methodNotAllowed: make(map[string]http.Handler),
+		},
+	}
+}
+
+// Group creates a router for routes under prefix. Middleware is applied in
+// order, after the middleware of the parent router.
+func (rt *Router) Group(prefix string, middleware ...Middleware) *Router {
+	mw := make([]Middleware, 0, len(rt.middleware)+len(middleware))
+	mw = append(mw, rt.middleware...)
+	mw = append(mw, middleware...)
+
+	return &Router{
+		table:      rt.table,
+		prefix:     rt.prefix + strings.TrimSuffix(prefix, "/"),
+		middleware: mw,
+	}
+}
+
+// Registering GET also registers HEAD.
+func (rt *Router) Handle(method, pattern string, handler http.Handler) {
+	full := rt.prefix + pattern
+	if full != "/" {
+		full = strings.TrimSuffix(full, "/")
+	}
+
+	methods := []string{method}
+	if method == http.MethodGet {
+		methods = append(methods, http.MethodHead)
+	}
+	for _, m := range methods {
+		rt.table.routes = append(rt.table.routes, &route{
+			method:   m,
+			pattern:  full,
+			segments: splitPath(full),
+			handler:  rt.wrap(handler),
+		})
+	}
+}
+
+func (rt *Router) HandleFunc(method, pattern string, f http.HandlerFunc) {
+	rt.Handle(method, pattern, f)
+}
+
+// Used for unmatched paths under this router's prefix.
+func (rt *Router) NotFound(handler http.Handler) {
+	rt.table.notFound[rt.prefix] = rt.wrap(handler)
+}
+
+// Used when the path matches but the method doesn't. The Allow header is
+// already set when the handler is called.
+func (rt *Router) MethodNotAllowed(handler http.Handler) {
+	rt.table.methodNotAllowed[rt.prefix] = rt.wrap(handler)
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pathSegments := splitPath(r.URL.Path)
+
+	var best *route
+	var bestParams map[string]string
+	allowed := make([]string, 0)
+	for _, rte := range rt.table.routes {
+		params, ok := matchSegments(rte.segments, pathSegments)
+		if !ok {
+			continue
+		}
+
+		if rte.method != r.Method && rte.method != MethodAny {
+			allowed = append(allowed, rte.method)
+			continue
+		}
+
+		if best == nil || moreSpecific(rte.segments, best.segments) ||
+			(!moreSpecific(best.segments, rte.segments) && best.method == MethodAny) {
+			best = rte
+			bestParams = params
+		}
+	}
+
+	if best != nil {
+		setRequestRoute(r.Context(), best.pattern)
+		ctx := context.WithValue(r.Context(), routeKey{}, &routeMatch{
+			pattern: best.pattern,
+			params:  bestParams,
+		})
+		best.handler.ServeHTTP(w, r.WithContext(ctx))
+		return
+	}
+
+	if len(allowed) > 0 {
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(dedupe(allowed), ", "))
+		rt.table.handlerFor(rt.table.methodNotAllowed, r.URL.Path, http.HandlerFunc(methodNotAllowed)).ServeHTTP(w, r)
+		return
+	}
+
+	rt.table.handlerFor(rt.table.notFound, r.URL.Path, http.HandlerFunc(http.NotFound)).ServeHTTP(w, r)
+}
+
+// The method and pattern of every route, in the order they were added,
+// including the HEAD routes that come with GET.
+func (rt *Router) Routes() []RouteInfo {
+	routes := make([]RouteInfo, 0, len(rt.table.routes))
+	for _, rte := range rt.table.routes {
+		routes = append(routes, RouteInfo{Method: rte.method, Pattern: rte.pattern})
+	}
+	return routes
+}
+
+type RouteInfo struct {
+	Method  string
+	Pattern string
+}
+
+// Returns the value of a path parameter of the matched route, or "" if the
+// route has no such parameter.
+func PathParam(r *http.Request, name string) string {
+	if m, ok := r.Context().Value(routeKey{}).(*routeMatch); ok {
+		return m.params[name]
+	}
+	return ""
+}
+
+// Returns the pattern of the matched route, like /user/{user}, which is
+// useful for grouping requests without the cardinality of the real paths.
+func RoutePattern(r *http.Request) string {
+	if m, ok := r.Context().Value(routeKey{}).(*routeMatch); ok {
+		return m.pattern
+	}
+	return ""
+}
+
+func (rt *Router) wrap(handler http.Handler) http.Handler {
+	for i := len(rt.middleware) - 1; i >= 0; i-- {
+		handler = rt.middleware[i](handler)
+	}
+	return handler
+}
+
+func (t *routeTable) handlerFor(handlers map[string]http.Handler, path string, fallback http.Handler) http.Handler {
+	found := false
+	bestPrefix := ""
+	handler := fallback
+	for prefix, h := range handlers {
+		if !hasPathPrefix(path, prefix) {
+			continue
+		}
+		if !found || len(prefix) > len(bestPrefix) {
+			found = true
+			bestPrefix = prefix
+			handler = h
+		}
+	}
+	return handler
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+func matchSegments(pattern, path []string) (map[string]string, bool) {
+	params := make(map[string]string)
+	if n := len(pattern) - 1; n >= 0 && isRest(pattern[n]) {
+		if len(path) < n {
+			return nil, false
+		}
+		params[pattern[n][1:len(pattern[n])-4]] = strings.Join(path[n:], "/")
+		pattern, path = pattern[:n], path[:n]
+	}
+	if len(pattern) != len(path) {
+		return nil, false
+	}
+
+	for i, seg := range pattern {
+		if isParam(seg) {
+			if path[i] == "" {
+				return nil, false
+			}
+			params[seg[1:len(seg)-1]] = path[i]
+		} else if seg != path[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// A static segment beats a parameter, and a parameter beats the rest, at the
+// first position they differ. If they don't, the shorter pattern wins, since
+// the longer one only matched with an empty rest.
+func moreSpecific(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if segmentRank(a[i]) != segmentRank(b[i]) {
+			return segmentRank(a[i]) < segmentRank(b[i])
+		}
+	}
+	return len(a) < len(b)
+}
+
+func segmentRank(seg string) int {
+	switch {
+	case isRest(seg):
+		return 2
+	case isParam(seg):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func isParam(seg string) bool {
+	return len(seg) > 2 && strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") && !isRest(seg)
+}
+
+func isRest(seg string) bool {
+	return len(seg) > 5 && strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "...}")
+}
+
+func hasPathPrefix(path, prefix string) bool {
+	if prefix == "" {
+		return true
+	}
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+func dedupe(sorted []string) []string {
+	out := make([]string, 0, len(sorted))
+	for i, s := range sorted {
+		if i == 0 || s != sorted[i-1] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,149 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func routeTestHandler(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name + ":" + PathParam(r, "user") + ":" + RoutePattern(r)))
+	}
+}
+
+func serveRoute(h http.Handler, method, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	return rec
+}
+
+func TestRouterMatch(t *testing.T) {
+	router := NewRouter()
+	router.HandleFunc(http.MethodGet, "/", routeTestHandler("root"))
+	router.HandleFunc(http.MethodGet, "/user/{user}", routeTestHandler("user"))
+	router.HandleFunc(http.MethodGet, "/user/me", routeTestHandler("me"))
+
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{http.MethodGet, "/", "root::/"},
+		{http.MethodGet, "/user/bob", "user:bob:/user/{user}"},
+		{http.MethodGet, "/user/bob/", "user:bob:/user/{user}"},
+		{http.MethodHead, "/user/bob", "user:bob:/user/{user}"},
+		{http.MethodGet, "/user/me", "me::/user/me"},
+	}
+
+	for _, tc := range tests {
+		rec := serveRoute(router, tc.method, tc.path)
+		if got := rec.Body.String(); got != tc.want {
+			t.Errorf("%s %s: Got %q, want %q", tc.method, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestRouterNotFound(t *testing.T) {
+	router := NewRouter()
+	router.HandleFunc(http.MethodGet, "/user/{user}", routeTestHandler("user"))
+
+	for _, path := range []string{"/nope", "/user", "/user/bob/docs"} {
+		if got, want := serveRoute(router, http.MethodGet, path).Code, http.StatusNotFound; got != want {
+			t.Errorf("%s: Got %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	router := NewRouter()
+	router.HandleFunc(http.MethodGet, "/follow", routeTestHandler("get"))
+	router.HandleFunc(http.MethodPost, "/follow", routeTestHandler("post"))
+
+	rec := serveRoute(router, http.MethodDelete, "/follow")
+	if got, want := rec.Code, http.StatusMethodNotAllowed; got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+	if got, want := rec.Header().Get("Allow"), "GET, HEAD, POST"; got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+}
+
+func TestRouterGroup(t *testing.T) {
+	router := NewRouter()
+	router.HandleFunc(http.MethodGet, "/", routeTestHandler("root"))
+
+	api := router.Group("/api", func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Group", "api")
+			next.ServeHTTP(w, r)
+		})
+	})
+	api.HandleFunc(http.MethodGet, "/users/{user}", routeTestHandler("api"))
+	api.NotFound(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "api not found", http.StatusNotFound)
+	}))
+
+	rec := serveRoute(router, http.MethodGet, "/api/users/bob")
+	if got, want := rec.Body.String(), "api:bob:/api/users/{user}"; got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+	if got, want := rec.Header().Get("X-Group"), "api"; got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+
+	rec = serveRoute(router, http.MethodGet, "/api/nope")
+	if got, want := rec.Body.String(), "api not found\n"; got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+
+	// Outside of the group, the default handler is used.
+	rec = serveRoute(router, http.MethodGet, "/apix")
+	if got, want := rec.Body.String(), "404 page not found\n"; got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+	if got := rec.Header().Get("X-Group"); got != "" {
+		t.Errorf("Got %q, want no group header", got)
+	}
+}
+
+func TestRouterRest(t *testing.T) {
+	router := NewRouter()
+	router.HandleFunc(http.MethodGet, "/user", routeTestHandler("list"))
+	router.HandleFunc(http.MethodGet, "/user/{user...}", routeTestHandler("rest"))
+	router.HandleFunc(http.MethodGet, "/user/{user}/feed", routeTestHandler("feed"))
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/user", "list::/user"},
+		{"/user/bob", "rest:bob:/user/{user...}"},
+		{"/user/bob/docs/1", "rest:bob/docs/1:/user/{user...}"},
+		{"/user/bob/feed", "feed:bob:/user/{user}/feed"},
+	}
+
+	for _, tc := range tests {
+		rec := serveRoute(router, http.MethodGet, tc.path)
+		if got := rec.Body.String(); got != tc.want {
+			t.Errorf("%s: Got %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestRouterMethodAny(t *testing.T) {
+	router := NewRouter()
+	router.HandleFunc(MethodAny, "/follow", routeTestHandler("any"))
+	router.HandleFunc(http.MethodPost, "/follow", routeTestHandler("post"))
+
+	for method, want := range map[string]string{
+		http.MethodGet:    "any::/follow",
+		http.MethodDelete: "any::/follow",
+		http.MethodPost:   "post::/follow",
+	} {
+		rec := serveRoute(router, method, "/follow")
+		if got := rec.Body.String(); got != want {
+			t.Errorf("%s: Got %q, want %q", method, got, want)
+		}
+	}
+}
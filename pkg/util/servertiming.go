@@ -0,0 +1,149 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const HeaderServerTiming = "Server-Timing"
+
+// The metrics that the services record.
+const (
+	TimingPool   = "pool"
+	TimingDB     = "db"
+	TimingHTTP   = "http"
+	TimingGRPC   = "grpc"
+	TimingRender = "render"
+	TimingTotal  = "total"
+)
+
+type timingKey struct{}
+
+// Sums up the time spent in each part of a request. Concurrent calls are
+// summed too, so a metric can add up to more than the total.
+type serverTiming struct {
+	mu     sync.Mutex
+	order  []string
+	totals map[string]time.Duration
+	counts map[string]int
+}
+
+// Adds d to the metric for the request in ctx, if it's being timed.
+func AddTiming(ctx context.Context, metric string, d time.Duration) {
+	st, ok := ctx.Value(timingKey{}).(*serverTiming)
+	if !ok {
+		return
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, ok := st.totals[metric]; !ok {
+		st.order = append(st.order, metric)
+	}
+	st.totals[metric] += d
+	st.counts[metric]++
+}
+
+// Call the returned func when the timed work is done:
+//
+//	defer util.StartTiming(ctx, util.TimingDB)()
+func StartTiming(ctx context.Context, metric string) func() {
+	start := time.Now()
+	return func() {
+		AddTiming(ctx, metric, time.Since(start))
+	}
+}
+
+// Sets the Server-Timing header with everything recorded up to when the
+// status is written. The handler has finished by then if it's behind
+// http.TimeoutHandler, which buffers the whole response.
+func ServerTiming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := &serverTiming{
+			totals: make(map[string]time.Duration),
+			counts: make(map[string]int),
+		}
+		tw := &timingWriter{
+			ResponseWriter: w,
+			st:             st,
+			start:          time.Now(),
+		}
+		ctx := context.WithValue(r.Context(), timingKey{}, st)
+		next.ServeHTTP(tw, r.WithContext(ctx))
+	})
+}
+
+type timingWriter struct {
+	http.ResponseWriter
+	st          *serverTiming
+	start       time.Time
+	wroteHeader bool
+}
+
+func (t *timingWriter) WriteHeader(status int) {
+	if !t.wroteHeader {
+		t.wroteHeader = true
+		t.Header().Set(HeaderServerTiming, t.st.header(time.Since(t.start)))
+	}
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *timingWriter) Write(b []byte) (int, error) {
+	if !t.wroteHeader {
+		t.WriteHeader(http.StatusOK)
+	}
+	return t.ResponseWriter.Write(b)
+}
+
+func (t *timingWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+func (st *serverTiming) header(total time.Duration) string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	parts := make([]string, 0, len(st.order)+1)
+	for _, metric := range st.order {
+		parts = append(parts, fmt.Sprintf(`%s;dur=%s;desc="%d calls"`, metric, formatMs(st.totals[metric]), st.counts[metric]))
+	}
+	parts = append(parts, fmt.Sprintf("%s;dur=%s", TimingTotal, formatMs(total)))
+	return strings.Join(parts, ", ")
+}
+
+func formatMs(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 2, 64)
+}
+
+// Parses Server-Timing header values into durations by metric name. Metrics
+// that show up more than once, like from a redirect chain, are summed.
+func ParseServerTiming(values []string) map[string]time.Duration {
+	out := make(map[string]time.Duration)
+	for _, value := range values {
+		for _, entry := range strings.Split(value, ",") {
+			params := strings.Split(entry, ";")
+			name := strings.TrimSpace(params[0])
+			if name == "" {
+				continue
+			}
+
+			var dur time.Duration
+			for _, param := range params[1:] {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) != 2 || kv[0] != "dur" {
+					continue
+				}
+				if ms, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					dur = time.Duration(ms * float64(time.Millisecond))
+				}
+			}
+			out[name] += dur
+		}
+	}
+	return out
+}
@@ -0,0 +1,44 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServerTiming(t *testing.T) {
+	handler := ServerTiming(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		AddTiming(r.Context(), TimingDB, 2*time.Millisecond)
+		AddTiming(r.Context(), TimingDB, 3*time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	timings := ParseServerTiming(rec.Header().Values(HeaderServerTiming))
+	if got, want := timings[TimingDB], 5*time.Millisecond; got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+	if _, ok := timings[TimingTotal]; !ok {
+		t.Errorf("Got %v, want a %s metric", timings, TimingTotal)
+	}
+}
+
+func TestParseServerTimingSumsRepeats(t *testing.T) {
+	timings := ParseServerTiming([]string{
+		`db;dur=1.5;desc="1 calls", total;dur=4`,
+		`db;dur=2.5, cache;desc="hit"`,
+	})
+
+	if got, want := timings["db"], 4*time.Millisecond; got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+	if got, want := timings["total"], 4*time.Millisecond; got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+	if got, ok := timings["cache"]; !ok || got != 0 {
+		t.Errorf("Got %v, want 0", got)
+	}
+}
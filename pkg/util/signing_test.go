@@ -0,0 +1,52 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServiceSignature(t *testing.T) {
+	serverKeys := NewServiceKeys([]byte("old key"))
+	server := httptest.NewServer(serverKeys.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The body is still there after the check read it.
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	})))
+	defer server.Close()
+
+	send := func(keys *ServiceKeys) error {
+		opts := []HttpClientOption{WithRetryPolicy(RetryPolicy{MaxAttempts: 1})}
+		if keys != nil {
+			opts = append(opts, WithSigner(keys.Sign))
+		}
+		body, err := NewHttpClient(opts...).SendContext(context.Background(), ReqOpts{
+			Method:      "POST",
+			Url:         server.URL + "/follow?x=1",
+			JsonContent: map[string]string{"src": "a"},
+		})
+		if err == nil && string(body) != `{"src":"a"}` {
+			t.Errorf("Got body %s, want it passed through", body)
+		}
+		return err
+	}
+
+	if err := send(NewServiceKeys([]byte("old key"))); err != nil {
+		t.Errorf("Got %v for a signed request, want nil", err)
+	}
+	if err := send(nil); !errors.Is(err, KindUnauthorized) {
+		t.Errorf("Got %v for an unsigned request, want unauthorized", err)
+	}
+	if err := send(NewServiceKeys([]byte("other key"))); !errors.Is(err, KindUnauthorized) {
+		t.Errorf("Got %v for the wrong key, want unauthorized", err)
+	}
+
+	// A client that hasn't picked up the new key yet still gets in.
+	serverKeys.SetKey([]byte("new key"))
+	if err := send(NewServiceKeys([]byte("old key"))); err != nil {
+		t.Errorf("Got %v with the previous key, want nil", err)
+	}
+}
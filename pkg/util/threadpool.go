@@ -2,50 +2,155 @@ package util
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/sync/semaphore"
 )
 
+var (
+	poolSize = DefaultRegistry.NewGaugeVec("threadpool_size",
+		"Max threads, by pool.", "pool")
+	poolInUse = DefaultRegistry.NewGaugeVec("threadpool_in_use",
+		"Threads in use, by pool.", "pool")
+	poolWait = DefaultRegistry.NewHistogramVec("threadpool_wait_seconds",
+		"Time spent waiting to acquire a thread, by pool.", DefaultBuckets, "pool")
+)
+
 type ThreadPool struct {
 	// Limits the number of threads.
 	sem *semaphore.Weighted
 	// Can wait for the outstanding threads to finish.
 	wg *sync.WaitGroup
-	// Catches errors from async executions.
-	ec chan error
+	// Number of functions that have acquired a thread and not finished yet.
+	outstanding int64
+	// Numbers the async tasks, to label their errors.
+	started int64
+
+	// Every error from async executions, in the order they failed.
+	mu   sync.Mutex
+	errs []*TaskError
+	// How many of errs Run has returned, for pools that don't fail fast.
+	reported int
+
+	// Only set for fail fast pools, and ends the context they handed out.
+	cancel context.CancelFunc
+
+	size int
+	// Only set for instrumented pools.
+	name string
+}
+
+// One async task's error, labeled with the task's name, or its number if it
+// was started with Run.
+type TaskError struct {
+	Task string
+	Err  error
+}
+
+func (e *TaskError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Task, e.Err)
 }
 
+func (e *TaskError) Unwrap() error {
+	return e.Err
+}
+
+// What Join returns when async tasks failed, with all of their errors.
+type PoolError struct {
+	Errors []*TaskError
+	// Set when Join's context ended before the tasks finished, so Errors
+	// only has the ones that failed by then.
+	Err error
+}
+
+func (e *PoolError) Error() string {
+	parts := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		parts = append(parts, err.Error())
+	}
+	msg := fmt.Sprintf("%d async tasks failed: %s", len(e.Errors), strings.Join(parts, "; "))
+	if e.Err != nil {
+		msg += fmt.Sprintf(" (stopped waiting for the rest: %v)", e.Err)
+	}
+	return msg
+}
+
+// Lets errors.Is and errors.As see every task's error, and the context's.
+func (e *PoolError) Is(target error) bool {
+	if e.Err != nil && errors.Is(e.Err, target) {
+		return true
+	}
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *PoolError) As(target interface{}) bool {
+	if e.Err != nil && errors.As(e.Err, target) {
+		return true
+	}
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Keeps taking tasks after some fail, though each failure is returned once by
+// the next Run, instead of starting its task. Join returns all of them.
 func NewThreadPool(n int) *ThreadPool {
-	t := &ThreadPool{
-		sem: semaphore.NewWeighted(int64(n)),
-		wg:  new(sync.WaitGroup),
-		ec:  make(chan error),
+	return &ThreadPool{
+		size: n,
+		sem:  semaphore.NewWeighted(int64(n)),
+		wg:   new(sync.WaitGroup),
 	}
+}
 
-	return t
+// Like errgroup.WithContext, the returned context ends when the first async
+// task fails, or when Join returns. Tasks should use it so the rest stop
+// early, and Run and RunSync won't start any more after a failure.
+func NewFailFastPool(ctx context.Context, n int) (*ThreadPool, context.Context) {
+	t := NewThreadPool(n)
+	ctx, t.cancel = context.WithCancel(ctx)
+	return t, ctx
 }
 
+// Runs f in a new thread once one is free, blocking until then.
 func (t *ThreadPool) Run(ctx context.Context, f func() error) error {
-	// In case all possible threads are blocked trying to return an error before
-	// Join() is called, return the error here to avoid blocking forever on
-	// acquire().
-	select {
-	case err := <-t.ec:
-		return fmt.Errorf("caught async error: %v", err)
-	default:
-		// Carry on.
-	}
+	return t.RunNamed(ctx, "", f)
+}
 
+// Like Run, but f's error is labeled with name.
+func (t *ThreadPool) RunNamed(ctx context.Context, name string, f func() error) error {
+	if err := t.stopped(); err != nil {
+		return err
+	}
+	// In case all possible threads are blocked on failing tasks, report their
+	// errors here too, so callers don't keep queueing work behind them.
+	if err := t.caught(); err != nil {
+		return err
+	}
 	if err := t.acquire(ctx); err != nil {
 		return err
 	}
 
+	n := atomic.AddInt64(&t.started, 1)
+	if name == "" {
+		name = fmt.Sprintf("task %d", n)
+	}
 	go func() {
 		defer t.release()
 		if err := f(); err != nil {
-			t.ec <- fmt.Errorf("async function execution error: %v", err)
+			t.fail(&TaskError{Task: name, Err: err})
 		}
 	}()
 
@@ -56,6 +161,9 @@ func (t *ThreadPool) Run(ctx context.Context, f func() error) error {
 // This is useful when the caller is already in a goroutine (for example,
 // handling an HTTP request).
 func (t *ThreadPool) RunSync(ctx context.Context, f func() error) error {
+	if err := t.stopped(); err != nil {
+		return err
+	}
 	if err := t.acquire(ctx); err != nil {
 		return err
 	}
@@ -64,7 +172,16 @@ func (t *ThreadPool) RunSync(ctx context.Context, f func() error) error {
 	return f()
 }
 
+// Waits for the async tasks to finish and returns a *PoolError with every one
+// that failed. If ctx ends first, it returns ctx's error, in a *PoolError
+// with the tasks that failed by then if there are any.
 func (t *ThreadPool) Join(ctx context.Context) error {
+	// A fail-fast pool's context ends either way, so tasks still running
+	// after ctx ends are told to stop.
+	if t.cancel != nil {
+		defer t.cancel()
+	}
+
 	c := make(chan struct{})
 	go func() {
 		defer close(c)
@@ -73,14 +190,71 @@ func (t *ThreadPool) Join(ctx context.Context) error {
 
 	select {
 	case <-c:
-		return nil
-	case err := <-t.ec:
-		return fmt.Errorf("caught async error: %v", err)
 	case <-ctx.Done():
-		return ctx.Err()
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if len(t.errs) == 0 {
+			return ctx.Err()
+		}
+		return &PoolError{Errors: append([]*TaskError(nil), t.errs...), Err: ctx.Err()}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.errs) == 0 {
+		return nil
+	}
+	return &PoolError{Errors: append([]*TaskError(nil), t.errs...)}
+}
+
+// Exports the pool's size, usage and wait times to DefaultRegistry, labeled
+// with name. Pools that are created often, like the simulator's, shouldn't be
+// instrumented.
+func (t *ThreadPool) Instrument(name string) *ThreadPool {
+	t.name = name
+	poolSize.Set(float64(t.size), name)
+	return t
+}
+
+// Useful for logging what work is cut off when Join() doesn't finish.
+func (t *ThreadPool) Outstanding() int {
+	return int(atomic.LoadInt64(&t.outstanding))
+}
+
+func (t *ThreadPool) fail(err *TaskError) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.errs = append(t.errs, err)
+	if t.cancel != nil {
+		t.cancel()
 	}
 }
 
+// A fail fast pool that's had a failure doesn't take more work.
+func (t *ThreadPool) stopped() error {
+	if t.cancel == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("pool stopped after an async error: %w", t.errs[0])
+}
+
+// The next async error that Run hasn't returned yet. Join still returns it.
+func (t *ThreadPool) caught() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.reported == len(t.errs) {
+		return nil
+	}
+	err := t.errs[t.reported]
+	t.reported++
+	return fmt.Errorf("caught async error: %w", err)
+}
+
 func (t *ThreadPool) acquire(ctx context.Context) error {
 	select {
 	case <-ctx.Done():
@@ -92,14 +266,26 @@ func (t *ThreadPool) acquire(ctx context.Context) error {
 		// Don't block.
 	}
 
+	start := time.Now()
 	if err := t.sem.Acquire(ctx, 1); err != nil {
 		return err
 	}
 	t.wg.Add(1)
+	atomic.AddInt64(&t.outstanding, 1)
+	AddTiming(ctx, TimingPool, time.Since(start))
+
+	if t.name != "" {
+		poolWait.Observe(time.Since(start).Seconds(), t.name)
+		poolInUse.Add(1, t.name)
+	}
 	return nil
 }
 
 func (t *ThreadPool) release() {
+	if t.name != "" {
+		poolInUse.Add(-1, t.name)
+	}
+	atomic.AddInt64(&t.outstanding, -1)
 	t.wg.Done()
 	t.sem.Release(1)
 }
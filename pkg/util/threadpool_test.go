@@ -2,6 +2,7 @@ package util
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync/atomic"
 	"testing"
@@ -94,6 +95,35 @@ func TestRunError(t *testing.T) {
 	}
 }
 
+func TestFailFastRunError(t *testing.T) {
+	pool, ctx := NewFailFastPool(context.Background(), 1)
+
+	err := pool.Run(ctx, func() error {
+		return fmt.Errorf("error!")
+	})
+
+	if err != nil {
+		t.Errorf("Got %v, want no error", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	// Unlike the default pool, it keeps refusing work.
+	for i := 0; i < 2; i++ {
+		if err := pool.Run(context.Background(), func() error { return nil }); err == nil {
+			t.Errorf("Got %v, want error", err)
+		}
+	}
+	ran := false
+	err = pool.RunSync(context.Background(), func() error {
+		ran = true
+		return nil
+	})
+	if err == nil || ran {
+		t.Errorf("Got %v and ran %v, want error and not run", err, ran)
+	}
+}
+
 func TestJoinError(t *testing.T) {
 	pool := NewThreadPool(1)
 
@@ -111,6 +141,83 @@ func TestJoinError(t *testing.T) {
 	}
 }
 
+func TestJoinAllErrors(t *testing.T) {
+	pool := NewThreadPool(3)
+	obj := &poolTestObj{}
+	errBad := errors.New("bad")
+
+	// None fail until they've all started, or Run would return the failures.
+	start := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		i := i
+		err := pool.RunNamed(context.Background(), fmt.Sprintf("user %d", i), func() error {
+			<-start
+			// Fail in order.
+			time.Sleep(time.Duration(i) * 20 * time.Millisecond)
+			obj.increment()
+			return errBad
+		})
+		if err != nil {
+			t.Errorf("Got %v, want no error", err)
+		}
+	}
+	close(start)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := pool.Join(ctx)
+
+	var poolErr *PoolError
+	if !errors.As(err, &poolErr) {
+		t.Fatalf("Got %v, want a PoolError", err)
+	}
+	if got, want := len(poolErr.Errors), 3; got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+	if got, want := poolErr.Errors[0].Task, "user 0"; got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+	if !errors.Is(err, errBad) {
+		t.Errorf("Got %v, want %v", err, errBad)
+	}
+	if got, want := obj.get(), 3; got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+}
+
+func TestFailFast(t *testing.T) {
+	pool, ctx := NewFailFastPool(context.Background(), 2)
+
+	pool.Run(ctx, func() error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+			return nil
+		}
+	})
+	pool.Run(ctx, func() error {
+		return errors.New("bad")
+	})
+
+	start := time.Now()
+	err := pool.Join(context.Background())
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Got %v, want the slow task canceled", time.Since(start))
+	}
+
+	var poolErr *PoolError
+	if !errors.As(err, &poolErr) {
+		t.Fatalf("Got %v, want a PoolError", err)
+	}
+	if got, want := poolErr.Errors[0].Task, "task 2"; got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Got %v, want %v", err, context.Canceled)
+	}
+}
+
 func TestEndContext(t *testing.T) {
 	pool := NewThreadPool(1)
 
@@ -147,3 +254,81 @@ func TestEndContext(t *testing.T) {
 		t.Errorf("Got %v, want error", err)
 	}
 }
+
+func TestOutstanding(t *testing.T) {
+	pool := NewThreadPool(2)
+
+	for i := 0; i < 2; i++ {
+		pool.Run(context.Background(), func() error {
+			time.Sleep(300 * time.Millisecond)
+			return nil
+		})
+	}
+
+	if got, want := pool.Outstanding(), 2; got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := pool.Join(ctx); err == nil {
+		t.Errorf("Got %v, want error", err)
+	}
+
+	pool.Join(context.Background())
+	if got, want := pool.Outstanding(), 0; got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+}
+
+func TestJoinEndedWithErrors(t *testing.T) {
+	pool := NewThreadPool(2)
+	errBad := errors.New("bad")
+
+	pool.Run(context.Background(), func() error { return errBad })
+	pool.Run(context.Background(), func() error {
+		time.Sleep(300 * time.Millisecond)
+		return nil
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := pool.Join(ctx)
+
+	var poolErr *PoolError
+	if !errors.As(err, &poolErr) {
+		t.Fatalf("Got %v, want a PoolError", err)
+	}
+	if got, want := len(poolErr.Errors), 1; got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+	if !errors.Is(err, errBad) {
+		t.Errorf("Got %v, want %v", err, errBad)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Got %v, want %v", err, context.DeadlineExceeded)
+	}
+	pool.Join(context.Background())
+}
+
+func TestJoinEndedCancelsFailFast(t *testing.T) {
+	pool, poolCtx := NewFailFastPool(context.Background(), 1)
+	pool.Run(poolCtx, func() error {
+		<-poolCtx.Done()
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := pool.Join(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Got %v, want %v", err, context.Canceled)
+	}
+
+	select {
+	case <-poolCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Got the pool's context still running after Join, want it ended")
+	}
+	pool.Join(context.Background())
+}
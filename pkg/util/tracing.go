@@ -0,0 +1,69 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"holosam/appengine/demo/pkg/trace"
+)
+
+const (
+	TraceExporterNone   = "none"
+	TraceExporterStdout = "stdout"
+	TraceExporterOTLP   = "otlp"
+)
+
+type TracingConfig struct {
+	Exporter     string `env:"TRACE_EXPORTER" default:"none" oneof:"none|stdout|otlp"`
+	OTLPEndpoint string `env:"OTEL_EXPORTER_OTLP_ENDPOINT" default:"http://localhost:4318"`
+}
+
+// Sets up span export, and returns the func that flushes it on shutdown.
+func InitTracing(service string, cfg TracingConfig) func(ctx context.Context) error {
+	trace.SetServiceName(service)
+
+	var exporter trace.Exporter
+	switch cfg.Exporter {
+	case TraceExporterStdout:
+		exporter = trace.NewStdoutExporter(os.Stdout)
+	case TraceExporterOTLP:
+		exporter = trace.NewOTLPExporter(cfg.OTLPEndpoint)
+	default:
+		return func(ctx context.Context) error { return nil }
+	}
+
+	trace.SetExporter(exporter)
+	return exporter.Shutdown
+}
+
+// Starts a server span for each request, continuing the caller's trace if
+// there's a traceparent header.
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote, _ := trace.Extract(r.Header)
+		ctx, span := trace.StartWithKind(r.Context(), r.Method+" "+r.URL.Path, trace.KindServer, remote)
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		if route := getRequestRoute(r.Context()); route != "" {
+			span.SetName(r.Method + " " + route)
+			span.SetAttr("http.route", route)
+		}
+		span.SetAttr("http.method", r.Method)
+		// Not the query string, which carries what users publish.
+		span.SetAttr("http.target", r.URL.Path)
+		span.SetAttr("http.status_code", sw.Status())
+		span.SetAttr("request_id", GetRequestID(r.Context()))
+		if user := GetRequestUser(r.Context()); user != "" {
+			span.SetAttr("user", user)
+		}
+
+		var err error
+		if sw.Status() >= 500 {
+			err = fmt.Errorf("HTTP status %d", sw.Status())
+		}
+		span.End(err)
+	})
+}
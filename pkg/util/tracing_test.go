@@ -0,0 +1,73 @@
+package util
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"holosam/appengine/demo/pkg/trace"
+)
+
+func TestTracingPropagation(t *testing.T) {
+	exporter := trace.NewMemoryExporter()
+	trace.SetExporter(exporter)
+	defer trace.SetExporter(nil)
+
+	downstream := NewRouter()
+	downstream.HandleFunc(http.MethodPost, "/follow", func(w http.ResponseWriter, r *http.Request) {
+		_, span := trace.Start(r.Context(), "DBClient.ModifyUser")
+		span.End(nil)
+	})
+	server := httptest.NewServer(Chain(downstream, RequestID, Tracing))
+	defer server.Close()
+
+	client := NewHttpClient()
+	upstream := NewRouter()
+	upstream.HandleFunc(http.MethodGet, "/follow", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := client.SendContext(r.Context(), ReqOpts{
+			Method: "POST",
+			Url:    server.URL + "/follow",
+		}); err != nil {
+			t.Errorf("Got %v, want no error", err)
+		}
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/follow", nil)
+	Chain(upstream, RequestID, Tracing).ServeHTTP(httptest.NewRecorder(), req)
+
+	want := "GET /follow\n" +
+		"  HTTP POST\n" +
+		"    POST /follow\n" +
+		"      DBClient.ModifyUser\n"
+	if got := exporter.Tree(); got != want {
+		t.Errorf("Got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestTracingHidesQuery(t *testing.T) {
+	exporter := trace.NewMemoryExporter()
+	trace.SetExporter(exporter)
+	defer trace.SetExporter(nil)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client := NewHttpClient()
+	h := Tracing(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client.SendContext(r.Context(), ReqOpts{Method: "GET", Url: server.URL + "/docs?text=secret"})
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/publish?user=bob&text=secret", nil))
+
+	for _, span := range exporter.Spans() {
+		for key, value := range span.Attributes {
+			if strings.Contains(fmt.Sprint(value), "secret") {
+				t.Errorf("Got %s=%v on %s, want no query string", key, value, span.Name)
+			}
+		}
+	}
+	if got, want := len(exporter.Spans()), 2; got != want {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+}
@@ -2,241 +2,128 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"html/template"
-	"log"
+	"flag"
 	"net/http"
-	"regexp"
-	"time"
 
 	"holosam/appengine/demo/pkg/database"
+	"holosam/appengine/demo/pkg/features"
+	"holosam/appengine/demo/pkg/feedsvc"
+	"holosam/appengine/demo/pkg/logging"
+	"holosam/appengine/demo/pkg/secrets"
+	"holosam/appengine/demo/pkg/userclient"
 	"holosam/appengine/demo/pkg/util"
 )
 
 var (
-	templates = template.Must(template.ParseGlob("templates/*.html"))
+	appYAML = flag.String("app-yaml", "", "An app.yaml to take env_variables from, for running locally. The environment still takes precedence.")
 
-	// https://github.com/gorilla/mux is the solution to this hack.
-	userRegex = regexp.MustCompile(`^/user/(\w+)`)
-
-	numSelfDocs = util.LoadEnvInt(util.EnvSelfDocs, 3)
-	numFeedDocs = util.LoadEnvInt(util.EnvFeedDocs, 5)
+	logger = logging.New("feed")
 )
 
-type Handler struct {
-	db       *database.DBClient
-	client   *util.HttpClient
-	project  string
-	baseTmpl *BaseTmpl
-}
-
-type BaseTmpl struct {
-	Headline  string
-	TextColor string
-}
-
-type FeedTmpl struct {
-	Headline string
-	User     string
-	Feed     []DocTmpl
-	Self     []DocTmpl
-}
+func main() {
+	flag.Parse()
+	logging.RedirectStdLog(logger)
 
-type DocTmpl struct {
-	Author string
-	Text   string
-}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-func (h *Handler) baseHandler(w http.ResponseWriter, r *http.Request) {
-	if err := templates.ExecuteTemplate(w, "land.html", h.baseTmpl); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	var cfg feedsvc.ServiceConfig
+	if *appYAML != "" {
+		if err := util.ApplyAppYAML(*appYAML, &cfg); err != nil {
+			logger.Fatalf(ctx, "Failed to apply app.yaml: %v", err)
+		}
 	}
-}
-
-// This exists because I don't know how to go directly to /user/username from
-// the land.html form entry, so this takes in the user as a param and then
-// redirects.
-func (h *Handler) redirectHandler(w http.ResponseWriter, r *http.Request) {
-	user, err := getParam(r, "user")
+	report, err := util.LoadConfig(&cfg)
 	if err != nil {
-		h.baseTmpl.Headline = "Missing username param"
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
-		return
+		logger.Fatalf(ctx, "%v", err)
 	}
+	logger.Infof(ctx, "Running version %s", cfg.Version)
 
-	http.Redirect(w, r, fmt.Sprintf("/user/%s", user), http.StatusFound)
-}
+	flushTraces := util.InitTracing("feed", cfg.Server.Tracing)
 
-func (h *Handler) loginHandler(w http.ResponseWriter, r *http.Request, user string) {
-	err := h.db.ModifyUser(r.Context(), user, func(u *database.User) {
-		u.Logins += 1
-		u.LastLogin = time.Now()
-	}, func() (database.User, error) {
-		return database.NewUser(user), nil
-	})
+	db, err := database.Init(ctx, cfg.Project, cfg.DB)
 	if err != nil {
-		log.Printf("User error: %v", err)
-		h.baseTmpl.Headline = "Failed to access user"
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
-		return
+		logger.Fatalf(ctx, "Failed to open db client: %v", err)
 	}
 
-	feedTmpl, err := h.buildFeed(r.Context(), user)
+	secretStore, err := secrets.Open(cfg.Secrets)
 	if err != nil {
-		log.Printf("Doc error: %v", err)
-		h.baseTmpl.Headline = "Failed to access docs"
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
-		return
-	}
-
-	if err := templates.ExecuteTemplate(w, "feed.html", feedTmpl); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	h.baseTmpl.Headline = util.LoadEnvString(util.EnvHeadline, "Welcome")
-}
-
-// Should surface docs from people who they aren't following too?
-func (h *Handler) buildFeed(ctx context.Context, user string) (*FeedTmpl, error) {
-	feed := &FeedTmpl{
-		Headline: fmt.Sprintf("Welcome %s!", user),
-		User:     user,
-		Feed:     make([]DocTmpl, 0),
-		Self:     make([]DocTmpl, 0),
+		logger.Fatalf(ctx, "Failed to open secrets: %v", err)
 	}
-
-	selfDocs, err := h.db.GetUserDocs(ctx, user, numSelfDocs)
+	flashKey, err := secretStore.Optional(ctx, util.EnvFlashKey)
 	if err != nil {
-		return nil, err
+		logger.Fatalf(ctx, "Failed to read %s: %v", util.EnvFlashKey, err)
 	}
-
-	for _, doc := range selfDocs {
-		feed.Self = append(feed.Self, DocTmpl{
-			Author: doc.Author,
-			Text:   doc.Text,
-		})
+	// With the env backend, leaving the key unset is how a local run turns
+	// signing off. Any other backend is a deployment, where a missing key
+	// would leave service-user taking unsigned calls.
+	readServiceKey := secretStore.Optional
+	if cfg.Secrets.Backend != secrets.BackendEnv {
+		readServiceKey = secretStore.Required
 	}
-
-	feedDocs, err := h.db.GetFollowingDocs(ctx, user, numFeedDocs)
+	serviceKey, err := readServiceKey(ctx, util.EnvServiceKey)
 	if err != nil {
-		return nil, err
+		logger.Fatalf(ctx, "Failed to read %s: %v", util.EnvServiceKey, err)
 	}
-
-	for _, doc := range feedDocs {
-		feed.Feed = append(feed.Feed, DocTmpl{
-			Author: doc.Author,
-			Text:   doc.Text,
-		})
-	}
-
-	return feed, nil
-}
-
-func (h *Handler) publishHandler(w http.ResponseWriter, r *http.Request) {
-	user, err := getParam(r, "user")
+	adminToken, err := secretStore.Optional(ctx, util.EnvAdminToken)
 	if err != nil {
-		log.Printf("Missing user param")
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
-		return
+		logger.Fatalf(ctx, "Failed to read %s: %v", util.EnvAdminToken, err)
 	}
-
-	text, err := getParam(r, "text")
+	tokenPepper, err := secretStore.Optional(ctx, util.EnvTokenPepper)
 	if err != nil {
-		log.Printf("Missing text param")
-		http.Redirect(w, r, fmt.Sprintf("/user/%s", user), http.StatusTemporaryRedirect)
-		return
-	}
-
-	pr := database.PublishRequest{
-		User: user,
-		Text: text,
-	}
-
-	_, err = h.client.Send(util.ReqOpts{
-		Method:      "POST",
-		Url:         fmt.Sprintf(util.UserServiceURL, h.project, "publish"),
-		JsonContent: pr,
-	})
+		logger.Fatalf(ctx, "Failed to read %s: %v", util.EnvTokenPepper, err)
+	}
+	flash := util.NewFlashStore(flashKey)
+	keys := util.NewServiceKeys(serviceKey)
+	admin := util.NewAdminToken(adminToken)
+	admin.SetPepper(tokenPepper)
+	secretStore.OnRotate(util.EnvFlashKey, flash.SetKey)
+	secretStore.OnRotate(util.EnvServiceKey, keys.SetKey)
+	secretStore.OnRotate(util.EnvAdminToken, admin.SetToken)
+	secretStore.OnRotate(util.EnvTokenPepper, admin.SetPepper)
+	go secretStore.Watch(ctx, cfg.Secrets.Refresh)
+
+	users, err := userclient.New(cfg.Project, cfg.Users, keys)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	http.Redirect(w, r, fmt.Sprintf("/user/%s", user), http.StatusFound)
-}
-
-func (h *Handler) followHandler(w http.ResponseWriter, r *http.Request) {
-	src, srcErr := getParam(r, "src")
-	dst, dstErr := getParam(r, "dst")
-	if srcErr != nil || dstErr != nil {
-		log.Printf("Missing src and/or dst user param")
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
-		return
-	}
-
-	fr := database.FollowRequest{
-		Src: src,
-		Dst: dst,
-	}
-
-	if _, err := h.client.Send(util.ReqOpts{
-		Method:      "POST",
-		Url:         fmt.Sprintf(util.UserServiceURL, h.project, "follow"),
-		JsonContent: fr,
-	}); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	http.Redirect(w, r, fmt.Sprintf("/user/%s", src), http.StatusFound)
-}
-
-func getParam(r *http.Request, param string) (string, error) {
-	params, ok := r.URL.Query()[param]
-	if !ok || len(params) == 0 {
-		return "", fmt.Errorf("missing %s param", param)
+		logger.Fatalf(ctx, "Failed to create user service client: %v", err)
+	}
+
+	var source features.Source
+	switch cfg.Flags.Source {
+	case features.SourceFile:
+		source = features.FileSource(cfg.Flags.File)
+	case features.SourceDatastore:
+		source = db.FlagSource(cfg.Flags.Entity)
+	}
+	flags := features.New(source, feedsvc.FlagDefinitions(cfg.Feed)...)
+	if err := flags.Reload(ctx); err != nil {
+		logger.Warningf(ctx, "Starting with default flags: %v", err)
+	}
+	go flags.Watch(ctx, cfg.Flags.Refresh)
+
+	handler := feedsvc.New(cfg.Feed, db, users, flags, flash)
+
+	router := util.NewRouter()
+	handler.Register(router)
+	adminRouter := router.Group("", admin.Require)
+	adminRouter.HandleFunc(http.MethodGet, "/metrics", util.MetricsHandler)
+	adminRouter.HandleFunc(http.MethodGet, "/debug/config", util.ConfigHandler(report))
+	adminRouter.HandleFunc(http.MethodGet, "/debug/flags", flags.Handler)
+	router.HandleFunc(http.MethodGet, "/healthz", util.HealthzHandler)
+	router.HandleFunc(http.MethodGet, "/readyz", util.ReadyzHandler(map[string]util.HealthCheck{
+		"datastore":    db.Ping,
+		"service-user": users.Ping,
+	}))
+	router.HandleFunc(http.MethodGet, "/_ah/warmup", util.WarmupHandler(map[string]util.HealthCheck{
+		"datastore": db.Warmup,
+		"templates": handler.LoadTemplates,
+	}))
+
+	server := util.NewHttpServer(cfg.Server.Port, router)
+	err = util.ListenAndServe(server, cfg.Server.ShutdownTimeout, func(ctx context.Context) error {
+		return users.Close()
+	}, db.Close, flushTraces)
+	if err != nil && err != http.ErrServerClosed {
+		logger.Fatalf(ctx, "Server error: %v", err)
 	}
-	return params[0], nil
-}
-
-func main() {
-	log.Printf("Running version %s", util.LoadEnvString("GAE_VERSION", "[not found]"))
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	db, err := database.Init(ctx)
-	if err != nil {
-		log.Fatalf("Failed to open db client: %v", err)
-	}
-	defer db.Close()
-
-	handler := &Handler{
-		db:      db,
-		client:  util.NewHttpClient(),
-		project: util.MustLoadEnvString(util.EnvCloudProject),
-		baseTmpl: &BaseTmpl{
-			Headline:  util.LoadEnvString(util.EnvHeadline, "Welcome"),
-			TextColor: util.LoadEnvString(util.EnvTextColor, "black"),
-		},
-	}
-
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", handler.baseHandler)
-	mux.HandleFunc("/publish", handler.publishHandler)
-	mux.HandleFunc("/follow", handler.followHandler)
-	mux.HandleFunc("/user", handler.redirectHandler)
-	mux.HandleFunc("/user/", func(w http.ResponseWriter, r *http.Request) {
-		matches := userRegex.FindStringSubmatch(r.URL.Path)
-		if len(matches) == 0 {
-			http.NotFound(w, r)
-			return
-		}
-		handler.loginHandler(w, r, matches[1])
-	})
-
-	server := util.NewHttpServer(mux)
-	log.Fatal(server.ListenAndServe())
 }
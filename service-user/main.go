@@ -2,92 +2,138 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"io/ioutil"
-	"log"
+	"flag"
+	"fmt"
+	"net"
 	"net/http"
 
 	"holosam/appengine/demo/pkg/database"
+	"holosam/appengine/demo/pkg/logging"
+	"holosam/appengine/demo/pkg/secrets"
+	"holosam/appengine/demo/pkg/usersvc"
 	"holosam/appengine/demo/pkg/util"
+
+	"google.golang.org/grpc"
 )
 
-type Handler struct {
-	db *database.DBClient
-}
+var (
+	appYAML = flag.String("app-yaml", "", "An app.yaml to take env_variables from, for running locally. The environment still takes precedence.")
 
-// Add a new record to the db, and notify all followers
-func (h *Handler) publishHandler(w http.ResponseWriter, r *http.Request) {
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer r.Body.Close()
+	logger = logging.New("user")
+)
+
+func main() {
+	flag.Parse()
+	logging.RedirectStdLog(logger)
 
-	var pr database.PublishRequest
-	err = json.Unmarshal(body, &pr)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var cfg usersvc.ServiceConfig
+	if *appYAML != "" {
+		if err := util.ApplyAppYAML(*appYAML, &cfg); err != nil {
+			logger.Fatalf(ctx, "Failed to apply app.yaml: %v", err)
+		}
+	}
+	report, err := util.LoadConfig(&cfg)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		logger.Fatalf(ctx, "%v", err)
 	}
 
-	err = h.db.WriteDocument(r.Context(), &pr)
+	flushTraces := util.InitTracing("user", cfg.Server.Tracing)
+
+	db, err := database.Init(ctx, cfg.Project, cfg.DB)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		logger.Fatalf(ctx, "Failed to open db client: %v", err)
 	}
-}
 
-func (h *Handler) followHandler(w http.ResponseWriter, r *http.Request) {
-	body, err := ioutil.ReadAll(r.Body)
+	secretStore, err := secrets.Open(cfg.Secrets)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		logger.Fatalf(ctx, "Failed to open secrets: %v", err)
 	}
-	defer r.Body.Close()
-
-	var fr database.FollowRequest
-	err = json.Unmarshal(body, &fr)
+	// With the env backend, leaving the key unset is how a local run turns
+	// signing off. Any other backend is a deployment, where a missing key
+	// would leave service-user taking unsigned calls.
+	readServiceKey := secretStore.Optional
+	if cfg.Secrets.Backend != secrets.BackendEnv {
+		readServiceKey = secretStore.Required
+	}
+	serviceKey, err := readServiceKey(ctx, util.EnvServiceKey)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		logger.Fatalf(ctx, "Failed to read %s: %v", util.EnvServiceKey, err)
 	}
-
-	err = h.db.ModifyUser(r.Context(), fr.Src, func(u *database.User) {
-		u.AddFollowing(fr.Dst)
-	}, database.ErrNoUser)
+	adminToken, err := secretStore.Optional(ctx, util.EnvAdminToken)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		logger.Fatalf(ctx, "Failed to read %s: %v", util.EnvAdminToken, err)
 	}
-
-	err = h.db.ModifyUser(r.Context(), fr.Dst, func(u *database.User) {
-		u.AddFollower(fr.Src)
-	}, database.ErrNoUser)
+	tokenPepper, err := secretStore.Optional(ctx, util.EnvTokenPepper)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		logger.Fatalf(ctx, "Failed to read %s: %v", util.EnvTokenPepper, err)
 	}
-}
+	keys := util.NewServiceKeys(serviceKey)
+	admin := util.NewAdminToken(adminToken)
+	admin.SetPepper(tokenPepper)
+	secretStore.OnRotate(util.EnvServiceKey, keys.SetKey)
+	secretStore.OnRotate(util.EnvAdminToken, admin.SetToken)
+	secretStore.OnRotate(util.EnvTokenPepper, admin.SetPepper)
+	go secretStore.Watch(ctx, cfg.Secrets.Refresh)
 
-func main() {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	handler := usersvc.New(db, keys)
 
-	db, err := database.Init(ctx)
-	if err != nil {
-		log.Fatalf("Failed to open db client: %v", err)
+	cleanup := make([]func(context.Context) error, 0)
+
+	if cfg.GRPCPort != 0 {
+		lis, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.GRPCPort))
+		if err != nil {
+			logger.Fatalf(ctx, "Failed to listen for gRPC: %v", err)
+		}
+
+		grpcServer := usersvc.NewGRPCServer(handler)
+		go func() {
+			// Returns nil after a graceful stop.
+			if err := grpcServer.Serve(lis); err != nil {
+				logger.Fatalf(ctx, "gRPC server error: %v", err)
+			}
+		}()
+		cleanup = append(cleanup, func(ctx context.Context) error {
+			return stopGRPC(ctx, grpcServer)
+		})
 	}
-	defer db.Close()
+	cleanup = append(cleanup, db.Close, flushTraces)
 
-	handler := &Handler{
-		db: db,
+	router := util.NewRouter()
+	handler.Register(router)
+	adminRouter := router.Group("", admin.Require)
+	adminRouter.HandleFunc(http.MethodGet, "/metrics", util.MetricsHandler)
+	adminRouter.HandleFunc(http.MethodGet, "/debug/config", util.ConfigHandler(report))
+	router.HandleFunc(http.MethodGet, "/healthz", util.HealthzHandler)
+	router.HandleFunc(http.MethodGet, "/readyz", util.ReadyzHandler(map[string]util.HealthCheck{
+		"datastore": db.Ping,
+	}))
+	router.HandleFunc(http.MethodGet, "/_ah/warmup", util.WarmupHandler(map[string]util.HealthCheck{
+		"datastore": db.Warmup,
+	}))
+
+	server := util.NewHttpServer(cfg.Server.Port, router)
+	err = util.ListenAndServe(server, cfg.Server.ShutdownTimeout, cleanup...)
+	if err != nil && err != http.ErrServerClosed {
+		logger.Fatalf(ctx, "Server error: %v", err)
 	}
+}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/publish", handler.publishHandler)
-	mux.HandleFunc("/follow", handler.followHandler)
+// Lets in-flight RPCs finish until ctx ends, then cancels them.
+func stopGRPC(ctx context.Context, server *grpc.Server) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.GracefulStop()
+	}()
 
-	server := util.NewHttpServer(mux)
-	log.Fatal(server.ListenAndServe())
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		server.Stop()
+		return fmt.Errorf("grpc graceful stop cut off: %v", ctx.Err())
+	}
 }